@@ -0,0 +1,460 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config holds every tunable of the server. Each field is read, in order of
+// precedence, from its flag (-jwt-secret), its environment variable
+// (JWT_SECRET), the optional config file (jwt_secret) and the defaults.
+// Fields tagged secret are redacted when the config is printed.
+type Config struct {
+	Port      string `key:"port" usage:"address to listen on, 5050 or host:5050"`
+	JWTSecret string `key:"jwt_secret" secret:"true" usage:"key signing login and invitation tokens"`
+	// How long a login token is valid
+	TokenTTL time.Duration `key:"token_ttl"`
+	// bcrypt cost of stored passwords, changing it only affects new hashes
+	BcryptCost int `key:"bcrypt_cost"`
+	// Largest JSON body a handler reads, bulk imports use ImportMaxBodySize
+	MaxBodySize int64 `key:"max_body_size"`
+	// Cookie holding the login token of browser clients that sign in with
+	// {"cookie": true}. SameSite is lax, strict or none, none needs Secure.
+	SessionCookieName     string `key:"session_cookie_name"`
+	SessionCookieSecure   bool   `key:"session_cookie_secure"`
+	SessionCookieSameSite string `key:"session_cookie_same_site"`
+
+	DbURI     string `key:"db_uri" secret:"uri"`
+	DbURITest string `key:"db_uri_test" secret:"uri"`
+	DbName    string `key:"db_name"`
+	// Use DbURITest instead of DbURI
+	TestingMode bool `key:"testing_mode"`
+	// Development only, lets a standalone server, which rejects transactions,
+	// run WithTransaction steps without atomicity
+	AllowNonAtomicTransactions bool `key:"allow_non_atomic_transactions"`
+
+	// Forward database changes made outside the handlers to websocket clients
+	WatchChanges bool `key:"watch_changes"`
+	// Messages queued per websocket client before new ones are dropped
+	WebsocketSendBuffer int `key:"websocket_send_buffer"`
+	// Modules the clients connect to, others are counted together as "other"
+	// in the metrics so the module in the path can't grow their labels
+	WebsocketModules []string `key:"websocket_modules"`
+	// Wait before reopening a change stream that failed
+	WatchRetryDelay time.Duration `key:"watch_retry_delay"`
+	// How long a handler broadcast suppresses the matching change event
+	OriginatedWindow time.Duration `key:"originated_window"`
+
+	// Profile cache in front of the database, disabled when CacheTTL is zero
+	CacheTTL  time.Duration `key:"cache_ttl"`
+	CacheSize int           `key:"cache_size"`
+
+	// Invitation links are InvitationURL?token=...
+	InvitationURL string        `key:"invitation_url"`
+	InvitationTTL time.Duration `key:"invitation_ttl"`
+
+	// Outgoing mail, messages are only logged when SMTPAddr is empty
+	SMTPAddr     string `key:"smtp_addr"`
+	SMTPUsername string `key:"smtp_username"`
+	SMTPPassword string `key:"smtp_password" secret:"true"`
+	MailFrom     string `key:"mail_from"`
+
+	// Users created per transaction by the bulk import and its upload limit
+	ImportBatchSize   int   `key:"import_batch_size"`
+	ImportMaxBodySize int64 `key:"import_max_body_size"`
+
+	// Origins allowed by CORS and the websocket handshake: *, an exact
+	// origin or a wildcard subdomain like https://*.example.com
+	CorsAllowedOrigins   []string      `key:"cors_allowed_origins"`
+	CorsAllowedHeaders   []string      `key:"cors_allowed_headers"`
+	CorsAllowedMethods   []string      `key:"cors_allowed_methods"`
+	CorsExposedHeaders   []string      `key:"cors_exposed_headers"`
+	CorsAllowCredentials bool          `key:"cors_allow_credentials"`
+	CorsMaxAge           time.Duration `key:"cors_max_age"`
+
+	// Addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For
+	// is believed, empty takes the client address from the connection
+	TrustedProxies []string `key:"trusted_proxies"`
+
+	// Serve https when both files are set, they are reloaded when changed on disk
+	TLSCertFile       string        `key:"tls_cert_file"`
+	TLSKeyFile        string        `key:"tls_key_file"`
+	TLSReloadInterval time.Duration `key:"tls_reload_interval"`
+	// 1.2 or 1.3
+	TLSMinVersion string `key:"tls_min_version"`
+	// Go names of the TLS 1.2 suites, empty keeps the Go defaults
+	TLSCipherSuites []string `key:"tls_cipher_suites"`
+	// Plain http listener redirecting to https, disabled when empty
+	TLSRedirectAddr string `key:"tls_redirect_addr"`
+	// Strict-Transport-Security sent over https, disabled when zero
+	HSTSMaxAge            time.Duration `key:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `key:"hsts_include_subdomains"`
+
+	// /metrics is served on this separate address when set, otherwise on the main one
+	MetricsAddr string `key:"metrics_addr"`
+	// Bearer token required to read /metrics, open when empty
+	MetricsToken string `key:"metrics_token" secret:"true"`
+
+	// Span exporter: none, stdout, file (TracingFile) or otlp (OTLP/HTTP to TracingEndpoint)
+	TracingExporter    string  `key:"tracing_exporter"`
+	TracingServiceName string  `key:"tracing_service_name"`
+	TracingFile        string  `key:"tracing_file"`
+	TracingEndpoint    string  `key:"tracing_endpoint"`
+	TracingInsecure    bool    `key:"tracing_insecure"`
+	TracingSampleRatio float64 `key:"tracing_sample_ratio"`
+
+	// debug, info, warn or error
+	LogLevel string `key:"log_level"`
+	// json or text
+	LogFormat string `key:"log_format"`
+
+	// Development only, HTML templates are read from this directory on every
+	// request instead of the ones embedded in the binary
+	PagesDevDir string `key:"pages_dev_dir" usage:"reload HTML templates from this directory, e.g. pages"`
+
+	// Redoc bundle loaded by /docs, empty uses the viewer embedded in the
+	// binary. A remote bundle needs its Subresource Integrity hash, e.g.
+	// sha384-..., a relative url to a self hosted copy needs none.
+	DocsScriptURL       string `key:"docs_script_url"`
+	DocsScriptIntegrity string `key:"docs_script_integrity"`
+
+	// Date /v1 and the unversioned paths were deprecated, sent in their
+	// Deprecation header. Empty while they are supported.
+	V1Deprecated string `key:"v1_deprecated"`
+	// Date after which /v1 and the unversioned paths may be removed, sent in
+	// their Sunset header, e.g. 2027-04-30. Empty until it is decided.
+	V1Sunset string `key:"v1_sunset"`
+
+	// How long /readyz fails while requests are still served once a shutdown
+	// signal arrives, so load balancers stop routing here before the drain
+	ShutdownDrainDelay time.Duration `key:"shutdown_drain_delay"`
+	// How long in-flight requests get to finish once draining starts
+	ShutdownTimeout time.Duration `key:"shutdown_timeout"`
+}
+
+// Default returns the values used when nothing else sets a field
+func Default() Config {
+	return Config{
+		Port:                  ":5050",
+		TokenTTL:              72 * time.Hour,
+		BcryptCost:            bcrypt.DefaultCost,
+		MaxBodySize:           1 << 20,
+		SessionCookieName:     "session",
+		SessionCookieSecure:   true,
+		SessionCookieSameSite: "lax",
+		WebsocketSendBuffer:   64,
+		WebsocketModules:      []string{"1"},
+		WatchRetryDelay:       5 * time.Second,
+		OriginatedWindow:      10 * time.Second,
+		V1Deprecated:          "2026-10-19",
+		InvitationTTL:         72 * time.Hour,
+		ImportBatchSize:       100,
+		ImportMaxBodySize:     10 << 20,
+		CorsAllowedOrigins:    []string{"*"},
+		CorsAllowedHeaders:    []string{"*"},
+		CorsAllowedMethods:    []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		CorsExposedHeaders:    []string{},
+		CorsMaxAge:            10 * time.Minute,
+		TLSReloadInterval:     time.Minute,
+		TLSMinVersion:         "1.2",
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		TracingExporter:       "none",
+		TracingServiceName:    "template-api-rest-go",
+		TracingFile:           "traces.jsonl",
+		TracingSampleRatio:    1,
+		LogLevel:              "info",
+		LogFormat:             "text",
+		ShutdownDrainDelay:    5 * time.Second,
+		ShutdownTimeout:       15 * time.Second,
+	}
+}
+
+// Subresource Integrity hashes, several may be given separated by spaces
+var validIntegrity = regexp.MustCompile(`^sha(256|384|512)-[A-Za-z0-9+/]+={0,2}( sha(256|384|512)-[A-Za-z0-9+/]+={0,2})*$`)
+
+// Characters mongo doesn't accept in database names
+var invalidDbName = regexp.MustCompile(`[/\\. "$*<>:|?]`)
+
+// normalize fills the values derived from others once every source is applied
+func (c *Config) normalize() {
+	if c.Port != "" && !strings.Contains(c.Port, ":") {
+		c.Port = ":" + c.Port
+	}
+	if c.MetricsAddr != "" && !strings.Contains(c.MetricsAddr, ":") {
+		c.MetricsAddr = ":" + c.MetricsAddr
+	}
+	if c.TLSRedirectAddr != "" && !strings.Contains(c.TLSRedirectAddr, ":") {
+		c.TLSRedirectAddr = ":" + c.TLSRedirectAddr
+	}
+	if c.TestingMode {
+		c.DbURI = c.DbURITest
+	}
+}
+
+// Validate reports every invalid field at once
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Port == "" {
+		invalid("port", "is required")
+	}
+	if c.JWTSecret == "" {
+		invalid("jwt_secret", "is required")
+	}
+	if c.TokenTTL <= 0 {
+		invalid("token_ttl", "must be positive")
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		invalid("bcrypt_cost", "must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if c.DbURI == "" {
+		if c.TestingMode {
+			invalid("db_uri_test", "is required in testing mode")
+		} else {
+			invalid("db_uri", "is required")
+		}
+	}
+	if c.DbName == "" {
+		invalid("db_name", "is required")
+	} else if invalidDbName.MatchString(c.DbName) {
+		invalid("db_name", "%q is not a valid database name", c.DbName)
+	}
+	if c.WebsocketSendBuffer <= 0 {
+		invalid("websocket_send_buffer", "must be positive")
+	}
+	if c.WatchRetryDelay <= 0 {
+		invalid("watch_retry_delay", "must be positive")
+	}
+	if c.OriginatedWindow <= 0 {
+		invalid("originated_window", "must be positive")
+	}
+	if c.CacheTTL < 0 {
+		invalid("cache_ttl", "can't be negative")
+	}
+	if c.CacheSize < 0 {
+		invalid("cache_size", "can't be negative")
+	}
+	if c.InvitationURL != "" {
+		if u, err := url.Parse(c.InvitationURL); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("invitation_url", "must be an absolute url")
+		}
+	}
+	if c.InvitationTTL <= 0 {
+		invalid("invitation_ttl", "must be positive")
+	}
+	if c.SMTPAddr != "" && c.MailFrom == "" {
+		invalid("mail_from", "is required when smtp_addr is set")
+	}
+	if c.MailFrom != "" {
+		if _, err := mail.ParseAddress(c.MailFrom); err != nil {
+			invalid("mail_from", "is not a valid address")
+		}
+	}
+	if c.MaxBodySize <= 0 {
+		invalid("max_body_size", "must be positive")
+	}
+	if c.DocsScriptIntegrity != "" && !validIntegrity.MatchString(c.DocsScriptIntegrity) {
+		invalid("docs_script_integrity", "must be a sha256-, sha384- or sha512- base64 hash")
+	} else if c.DocsScriptIntegrity == "" && !sameOriginURL(c.DocsScriptURL) {
+		invalid("docs_script_integrity", "is required to load docs_script_url from another origin")
+	}
+	var v1Deprecated, v1Sunset time.Time
+	if c.V1Deprecated != "" {
+		var err error
+		if v1Deprecated, err = time.Parse(time.DateOnly, c.V1Deprecated); err != nil {
+			invalid("v1_deprecated", "must be a date like 2026-10-19")
+		}
+	}
+	if c.V1Sunset != "" {
+		var err error
+		if v1Sunset, err = time.Parse(time.DateOnly, c.V1Sunset); err != nil {
+			invalid("v1_sunset", "must be a date like 2027-04-30")
+		}
+	}
+	if !v1Sunset.IsZero() && c.V1Deprecated == "" {
+		invalid("v1_sunset", "needs v1_deprecated")
+	} else if !v1Sunset.IsZero() && !v1Deprecated.IsZero() && v1Sunset.Before(v1Deprecated) {
+		invalid("v1_sunset", "can't be before v1_deprecated")
+	}
+	if c.SessionCookieName == "" {
+		invalid("session_cookie_name", "is required")
+	}
+	switch c.SessionCookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.SessionCookieSecure {
+			invalid("session_cookie_same_site", "none requires session_cookie_secure")
+		}
+	default:
+		invalid("session_cookie_same_site", "must be lax, strict or none")
+	}
+	if c.ImportBatchSize <= 0 {
+		invalid("import_batch_size", "must be positive")
+	}
+	if c.ImportMaxBodySize <= 0 {
+		invalid("import_max_body_size", "must be positive")
+	}
+	for _, origin := range c.CorsAllowedOrigins {
+		if origin == "*" {
+			// Browsers reject credentialed responses allowed for any origin
+			if c.CorsAllowCredentials {
+				invalid("cors_allowed_origins", "* can't be combined with cors_allow_credentials, list the origins")
+			}
+			continue
+		}
+		if u, err := url.Parse(strings.Replace(origin, "*.", "", 1)); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Count(origin, "*") > 1 {
+			invalid("cors_allowed_origins", "%q must be scheme://host[:port], host may start with *.", origin)
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parsePrefix(proxy); err != nil {
+			invalid("trusted_proxies", "%q must be an address or a CIDR range", proxy)
+		}
+	}
+	if len(c.CorsAllowedMethods) == 0 {
+		invalid("cors_allowed_methods", "is required")
+	}
+	if c.CorsMaxAge < 0 {
+		invalid("cors_max_age", "can't be negative")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		invalid("tls_cert_file", "tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSMinVersion != "1.2" && c.TLSMinVersion != "1.3" {
+		invalid("tls_min_version", "must be 1.2 or 1.3")
+	}
+	if c.TLSEnabled() && c.TLSReloadInterval <= 0 {
+		invalid("tls_reload_interval", "must be positive")
+	}
+	if c.TLSRedirectAddr != "" && !c.TLSEnabled() {
+		invalid("tls_redirect_addr", "requires tls_cert_file and tls_key_file")
+	}
+	if c.HSTSMaxAge < 0 {
+		invalid("hsts_max_age", "can't be negative")
+	}
+	switch c.TracingExporter {
+	case "none", "stdout":
+	case "file":
+		if c.TracingFile == "" {
+			invalid("tracing_file", "is required by the file exporter")
+		}
+	case "otlp":
+		if c.TracingEndpoint == "" {
+			invalid("tracing_endpoint", "is required by the otlp exporter")
+		}
+	default:
+		invalid("tracing_exporter", "must be none, stdout, file or otlp")
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		invalid("tracing_sample_ratio", "must be between 0 and 1")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		invalid("log_level", "must be debug, info, warn or error")
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		invalid("log_format", "must be json or text")
+	}
+	if c.ShutdownDrainDelay < 0 {
+		invalid("shutdown_drain_delay", "can't be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout", "must be positive")
+	}
+	return errors.Join(errs...)
+}
+
+// TrustedProxyPrefixes returns TrustedProxies as ranges, addresses are
+// ranges of a single address
+func (c *Config) TrustedProxyPrefixes() []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parsePrefix(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// sameOriginURL tells if a url given to the browser points to the page origin
+func sameOriginURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Host == "" && u.Scheme == ""
+}
+
+// TLSEnabled reports whether the server speaks https
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// SessionSameSite is the SameSite mode of SessionCookieSameSite
+func (c *Config) SessionSameSite() http.SameSite {
+	switch c.SessionCookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// AllowsCredentialedOrigin is the stricter policy of requests a browser
+// authenticates with a cookie: * doesn't count, the origin must be listed.
+func (c *Config) AllowsCredentialedOrigin(origin string) bool {
+	for _, allowed := range c.CorsAllowedOrigins {
+		if allowed == "*" {
+			return false
+		}
+	}
+	return origin != "" && c.AllowsOrigin(origin)
+}
+
+// AllowsOrigin applies the CORS origin policy. Requests without an Origin
+// header don't come from a browser and are always allowed.
+func (c *Config) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.CorsAllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// https://*.example.com matches any subdomain, not example.com itself
+		if prefix, suffix, ok := strings.Cut(allowed, "*."); ok {
+			rest, found := strings.CutPrefix(origin, prefix)
+			subdomain, matched := strings.CutSuffix(rest, "."+suffix)
+			if found && matched && subdomain != "" && !strings.ContainsAny(subdomain, "/:@") {
+				return true
+			}
+		}
+	}
+	return false
+}
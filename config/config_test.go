@@ -0,0 +1,65 @@
+package config
+
+import "testing"
+
+func TestAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin header", []string{"https://app.example.com"}, "", true},
+		{"any origin", []string{"*"}, "https://evil.com", true},
+		{"exact origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"exact origin ignores case", []string{"https://App.Example.com"}, "https://app.example.COM", true},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.com", false},
+		{"other port", []string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"wildcard nested subdomain", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"wildcard with port", []string{"https://*.example.com:8443"}, "https://app.example.com:8443", true},
+		{"wildcard bare domain", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard empty subdomain", []string{"https://*.example.com"}, "https://.example.com", false},
+		{"wildcard path trick", []string{"https://*.example.com"}, "https://evil.com/.example.com", false},
+		{"wildcard userinfo trick", []string{"https://*.example.com"}, "https://evil.com@app.example.com", false},
+		{"wildcard port trick", []string{"https://*.example.com"}, "https://evil.com:1.example.com", false},
+		{"wildcard suffix trick", []string{"https://*.example.com"}, "https://app.example.com.evil.com", false},
+		{"wildcard lookalike domain", []string{"https://*.example.com"}, "https://evilexample.com", false},
+		{"wildcard other scheme", []string{"https://*.example.com"}, "http://app.example.com", false},
+		{"wildcard other port", []string{"https://*.example.com"}, "https://app.example.com:8443", false},
+		{"second entry", []string{"https://app.example.com", "https://*.example.org"}, "https://www.example.org", true},
+		{"nothing allowed", []string{}, "https://app.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Config{CorsAllowedOrigins: test.allowed}
+			if got := c.AllowsOrigin(test.origin); got != test.want {
+				t.Errorf("AllowsOrigin(%q) with %q = %v, want %v", test.origin, test.allowed, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAllowsCredentialedOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"listed origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"any origin", []string{"*"}, "https://app.example.com", false},
+		{"any origin besides a listed one", []string{"https://app.example.com", "*"}, "https://app.example.com", false},
+		{"no origin header", []string{"https://app.example.com"}, "", false},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Config{CorsAllowedOrigins: test.allowed}
+			if got := c.AllowsCredentialedOrigin(test.origin); got != test.want {
+				t.Errorf("AllowsCredentialedOrigin(%q) with %q = %v, want %v", test.origin, test.allowed, got, test.want)
+			}
+		})
+	}
+}
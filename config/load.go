@@ -0,0 +1,226 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variable naming the config file when the -config flag is not given
+const fileEnv = "CONFIG_FILE"
+
+// Load builds the config from the defaults, the optional file, the
+// environment and the command line args (without the program name), then
+// validates it. Later sources override earlier ones.
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(fileEnv), "yaml or toml config file, $"+fileEnv)
+	overrides := map[string]string{}
+	eachField(&Config{}, func(key string, field reflect.StructField, _ reflect.Value) {
+		usage := field.Tag.Get("usage")
+		if usage != "" {
+			usage += ", "
+		}
+		flags.Func(flagName(key), usage+"$"+envName(key), func(value string) error {
+			overrides[key] = value
+			return nil
+		})
+	})
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	fileValues := map[string]string{}
+	if *file != "" {
+		var err error
+		if fileValues, err = loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	config := Default()
+	var errs []error
+	eachField(&config, func(key string, _ reflect.StructField, value reflect.Value) {
+		raw, ok := fileValues[key]
+		if env, set := os.LookupEnv(envName(key)); set {
+			raw, ok = env, true
+		}
+		if override, set := overrides[key]; set {
+			raw, ok = override, true
+		}
+		if !ok {
+			return
+		}
+		if err := setValue(value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	config.normalize()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// loadFile reads the file keys as raw values, parsed like the environment ones
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		err = errors.New("config file must be .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	known := map[string]bool{}
+	eachField(&Config{}, func(key string, _ reflect.StructField, _ reflect.Value) {
+		known[key] = true
+	})
+	values := map[string]string{}
+	for key, value := range document {
+		if !known[key] {
+			return nil, fmt.Errorf("%s: unknown key %s", path, key)
+		}
+		if list, ok := value.([]interface{}); ok {
+			items := []string{}
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		} else {
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// String lists every field with the secrets redacted, safe to log
+func (c Config) String() string {
+	var b strings.Builder
+	for _, field := range c.redacted() {
+		fmt.Fprintf(&b, "%s=%s\n", field.key, field.value)
+	}
+	return b.String()
+}
+
+// LogValue logs the config as a group with the secrets redacted
+func (c Config) LogValue() slog.Value {
+	attrs := []slog.Attr{}
+	for _, field := range c.redacted() {
+		attrs = append(attrs, slog.String(field.key, field.value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type shownField struct {
+	key   string
+	value string
+}
+
+func (c Config) redacted() []shownField {
+	fields := []shownField{}
+	eachField(&c, func(key string, field reflect.StructField, value reflect.Value) {
+		shown := fmt.Sprint(value.Interface())
+		switch field.Tag.Get("secret") {
+		case "true":
+			if shown != "" {
+				shown = "[redacted]"
+			}
+		case "uri":
+			if u, err := url.Parse(shown); err == nil {
+				shown = u.Redacted()
+			} else {
+				shown = "[redacted]"
+			}
+		}
+		fields = append(fields, shownField{key: key, value: shown})
+	})
+	return fields
+}
+
+// eachField calls fn with the key and settable value of every configurable field
+func eachField(config *Config, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("key"); key != "" {
+			fn(key, t.Field(i), v.Field(i))
+		}
+	}
+}
+
+// setValue parses raw into the field, lists are comma separated
+func setValue(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch value.Interface().(type) {
+	case string:
+		value.SetString(raw)
+	case bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case int, int64:
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case float64:
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case time.Duration:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(parsed))
+	case []string:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+func envName(key string) string {
+	return strings.ToUpper(key)
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
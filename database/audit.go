@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit log is append only, entries are never updated or deleted
+func (repo *MongoRepo) InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("audit")
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return err
+	}
+	if scoped {
+		entry.OrganizationId = organizationId.Hex()
+	}
+	_, err = collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (repo *MongoRepo) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("audit")
+	query := bson.M{}
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scoped {
+		query["organizationId"] = organizationId.Hex()
+	}
+	if filter.ActorId != "" {
+		query["actorId"] = filter.ActorId
+	}
+	if filter.TargetId != "" {
+		query["targetId"] = filter.TargetId
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lte"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.AuditEntry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
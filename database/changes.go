@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error code returned when a resume token is older than the oplog
+const changeStreamHistoryLost = 286
+
+// changeDecoders turn the full document of a change into the value sent to
+// clients and the organizations it belongs to, collections without a decoder
+// are sent as plain documents scoped by their organizationId field.
+var changeDecoders = map[string]func(raw bson.Raw) (interface{}, []string, error){
+	"users": decodeUserProfile,
+}
+
+type changeDocument struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		Id bson.RawValue `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+func (repo *MongoRepo) WatchCollection(ctx context.Context, collection string, resumeToken []byte, handle func(event models.ChangeEvent) error) error {
+	coll := repo.client.Database(repo.dbName).Collection(collection)
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(resumeToken) > 0 {
+		opts.SetResumeAfter(bson.Raw(resumeToken))
+	}
+	stream, err := coll.Watch(ctx, mongo.Pipeline{}, opts)
+	var cmdErr mongo.CommandError
+	if err != nil && len(resumeToken) > 0 && errors.As(err, &cmdErr) && cmdErr.Code == changeStreamHistoryLost {
+		// The token fell off the oplog, nothing can be replayed so start from now
+		slog.WarnContext(ctx, "Resume token is no longer valid, watching from now", "collection", collection)
+		stream, err = coll.Watch(ctx, mongo.Pipeline{}, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change changeDocument
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		event := models.ChangeEvent{
+			Collection:  collection,
+			Operation:   change.OperationType,
+			DocumentId:  documentKeyString(change.DocumentKey.Id),
+			ResumeToken: []byte(stream.ResumeToken()),
+		}
+		switch change.OperationType {
+		case "insert", "update", "replace":
+			if change.FullDocument == nil {
+				// Deleted before the lookup ran, the delete event will follow
+				continue
+			}
+			event.Document, event.Organizations, err = decodeChangeDocument(collection, change.FullDocument)
+			if err != nil {
+				return err
+			}
+		case "delete":
+			event.Document = event.DocumentId
+		case "invalidate":
+			return errors.New("change stream invalidated for " + collection)
+		default:
+			continue
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+func (repo *MongoRepo) GetResumeToken(ctx context.Context, stream string) ([]byte, error) {
+	collection := repo.client.Database(repo.dbName).Collection("resume_tokens")
+	var token models.ResumeToken
+	err := collection.FindOne(ctx, bson.M{"_id": stream}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token.Token, nil
+}
+
+func (repo *MongoRepo) SaveResumeToken(ctx context.Context, stream string, token []byte) error {
+	collection := repo.client.Database(repo.dbName).Collection("resume_tokens")
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": stream}, bson.M{"$set": bson.M{"token": token}}, options.Update().SetUpsert(true))
+	return err
+}
+
+func decodeChangeDocument(collection string, raw bson.Raw) (interface{}, []string, error) {
+	if decode, ok := changeDecoders[collection]; ok {
+		return decode(raw)
+	}
+	var document bson.M
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, nil, err
+	}
+	organizations := []string{}
+	if value, err := raw.LookupErr("organizationId"); err == nil {
+		organizations = append(organizations, documentKeyString(value))
+	}
+	return document, organizations, nil
+}
+
+func decodeUserProfile(raw bson.Raw) (interface{}, []string, error) {
+	var user models.User
+	if err := bson.Unmarshal(raw, &user); err != nil {
+		return nil, nil, err
+	}
+	organizations := []string{}
+	for _, membership := range user.Memberships {
+		organizations = append(organizations, membership.OrganizationId.Hex())
+	}
+	// Populate profile, the password and other memberships never leave the database
+	return &models.Profile{
+		Id:     user.Id,
+		Name:   user.Name,
+		Email:  user.Email,
+		Roles:  user.Roles,
+		Locale: user.Locale,
+	}, organizations, nil
+}
+
+func documentKeyString(value bson.RawValue) string {
+	if oid, ok := value.ObjectIDOK(); ok {
+		return oid.Hex()
+	}
+	if str, ok := value.StringValueOK(); ok {
+		return str
+	}
+	return value.String()
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (repo *MongoRepo) InsertGroup(ctx context.Context, group *models.InsertGroup) (*models.Group, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	document := *group
+	if scoped {
+		document.OrganizationId = organizationId.Hex()
+	}
+	if document.Members == nil {
+		document.Members = []primitive.ObjectID{}
+	}
+	result, err := collection.InsertOne(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetGroupById(ctx, result.InsertedID.(primitive.ObjectID).Hex())
+}
+
+func (repo *MongoRepo) GetGroupById(ctx context.Context, id string) (*models.Group, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var group models.Group
+	err = collection.FindOne(ctx, filter).Decode(&group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (repo *MongoRepo) ListGroups(ctx context.Context) ([]models.Group, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	groups := []models.Group{}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (repo *MongoRepo) UpdateGroup(ctx context.Context, data models.UpdateGroup) (*models.Group, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, data.Id)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	if data.Name != "" {
+		set["name"] = data.Name
+	}
+	if data.Roles != nil {
+		set["roles"] = data.Roles
+	}
+	// Mongo rejects an empty $set, nothing changes
+	if len(set) == 0 {
+		return repo.GetGroupById(ctx, data.Id)
+	}
+	err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}).Err()
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetGroupById(ctx, data.Id)
+}
+
+func (repo *MongoRepo) DeleteGroup(ctx context.Context, id string) error {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, id)
+	if err != nil {
+		return err
+	}
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (repo *MongoRepo) AddGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	return repo.updateGroupMembers(ctx, groupId, userId, "$addToSet")
+}
+
+func (repo *MongoRepo) RemoveGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	return repo.updateGroupMembers(ctx, groupId, userId, "$pull")
+}
+
+func (repo *MongoRepo) updateGroupMembers(ctx context.Context, groupId string, userId string, operator string) (*models.Group, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, err
+	}
+	err = collection.FindOneAndUpdate(ctx, filter, bson.M{operator: bson.M{"members": oid}}).Err()
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetGroupById(ctx, groupId)
+}
+
+// removeFromGroups drops the user from the groups of the organization in ctx, or from every group when unscoped
+func (repo *MongoRepo) removeFromGroups(ctx context.Context, userId primitive.ObjectID) error {
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	filter, err := scopeGroups(ctx, "")
+	if err != nil {
+		return err
+	}
+	filter["members"] = userId
+	_, err = collection.UpdateMany(ctx, filter, bson.M{"$pull": bson.M{"members": userId}})
+	return err
+}
+
+// effectiveGroups returns the groups the given users belong to in the scope
+// of ctx: the groups of the organization plus the global ones. A nil list of
+// users loads every group of the scope.
+func (repo *MongoRepo) effectiveGroups(ctx context.Context, userIds []primitive.ObjectID) ([]models.Group, error) {
+	collection := repo.client.Database(repo.dbName).Collection("groups")
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	global := bson.M{"organizationId": bson.M{"$exists": false}}
+	filter := global
+	if scoped {
+		filter = bson.M{"$or": bson.A{global, bson.M{"organizationId": organizationId.Hex()}}}
+	}
+	if userIds != nil {
+		filter = bson.M{"$and": bson.A{filter, bson.M{"members": bson.M{"$in": userIds}}}}
+	}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	groups := []models.Group{}
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// applyGroups adds the groups the profile belongs to and the roles they grant
+func applyGroups(profile *models.Profile, groups []models.Group) {
+	for _, group := range groups {
+		for _, member := range group.Members {
+			if member != profile.Id {
+				continue
+			}
+			// Global groups span organizations, roles are only granted by the
+			// groups of one, like memberships
+			if group.OrganizationId != "" {
+				profile.Roles = mergeRoles(profile.Roles, organizationRoles(group.Roles))
+			}
+			profile.Groups = append(profile.Groups, group.Id.Hex())
+			break
+		}
+	}
+}
+
+// scopeGroups filters groups by id, when given, and by the organization in ctx
+func scopeGroups(ctx context.Context, id string) (bson.M, error) {
+	filter := bson.M{}
+	if id != "" {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = oid
+	}
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scoped {
+		filter["organizationId"] = organizationId.Hex()
+	}
+	return filter, nil
+}
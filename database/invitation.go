@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (repo *MongoRepo) InsertInvitation(ctx context.Context, invitation *models.InsertInvitation) (*models.Invitation, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	document := *invitation
+	if scoped {
+		document.OrganizationId = organizationId.Hex()
+	}
+	result, err := collection.InsertOne(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetInvitationById(ctx, result.InsertedID.(primitive.ObjectID).Hex())
+}
+
+func (repo *MongoRepo) GetInvitationById(ctx context.Context, id string) (*models.Invitation, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	filter, err := scopeInvitations(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var invitation models.Invitation
+	err = collection.FindOne(ctx, filter).Decode(&invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (repo *MongoRepo) ListInvitations(ctx context.Context, pendingOnly bool) ([]models.Invitation, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	filter, err := scopeInvitations(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if pendingOnly {
+		for key, value := range pendingInvitation() {
+			filter[key] = value
+		}
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	invitations := []models.Invitation{}
+	err = cursor.All(ctx, &invitations)
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// RenewInvitation moves the expiration of an invitation that wasn't accepted or revoked
+func (repo *MongoRepo) RenewInvitation(ctx context.Context, id string, expiresAt time.Time) (*models.Invitation, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	filter, err := scopeInvitations(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	filter["acceptedAt"] = bson.M{"$exists": false}
+	filter["revokedAt"] = bson.M{"$exists": false}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"expiresAt": expiresAt}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return repo.GetInvitationById(ctx, id)
+}
+
+func (repo *MongoRepo) RevokeInvitation(ctx context.Context, id string) error {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	filter, err := scopeInvitations(ctx, id)
+	if err != nil {
+		return err
+	}
+	filter["acceptedAt"] = bson.M{"$exists": false}
+	filter["revokedAt"] = bson.M{"$exists": false}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AcceptInvitation marks a pending invitation as accepted, it fails if the
+// invitation was already accepted, revoked or expired.
+func (repo *MongoRepo) AcceptInvitation(ctx context.Context, id string) error {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("invitations")
+	filter, err := scopeInvitations(ctx, id)
+	if err != nil {
+		return err
+	}
+	for key, value := range pendingInvitation() {
+		filter[key] = value
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"acceptedAt": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func pendingInvitation() bson.M {
+	return bson.M{
+		"acceptedAt": bson.M{"$exists": false},
+		"revokedAt":  bson.M{"$exists": false},
+		"expiresAt":  bson.M{"$gt": time.Now().UTC()},
+	}
+}
+
+// scopeInvitations filters invitations by id, when given, and by the organization in ctx
+func scopeInvitations(ctx context.Context, id string) (bson.M, error) {
+	filter := bson.M{}
+	if id != "" {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = oid
+	}
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scoped {
+		filter["organizationId"] = organizationId.Hex()
+	}
+	return filter, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migration is applied once, its id is recorded in the migrations collection
+type migration struct {
+	id string
+	up func(ctx context.Context, db *mongo.Database) error
+}
+
+// Append only, never reorder or edit an applied migration
+var migrations = []migration{
+	{
+		id: "0001_users_email_unique",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "memberships.organizationId", Value: 1}}},
+			})
+			return err
+		},
+	},
+	{
+		id: "0002_groups_invitations_audit",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("groups").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "organizationId", Value: 1}, {Key: "name", Value: 1}}},
+				{Keys: bson.D{{Key: "members", Value: 1}}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("invitations").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "organizationId", Value: 1}, {Key: "createdAt", Value: -1}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("audit").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "organizationId", Value: 1}, {Key: "createdAt", Value: -1}},
+			})
+			return err
+		},
+	},
+}
+
+// Migrate applies the pending migrations in order
+func (repo *MongoRepo) Migrate(ctx context.Context) error {
+	db := repo.client.Database(repo.dbName)
+	applied := db.Collection("migrations")
+	for _, m := range migrations {
+		err := applied.FindOne(ctx, bson.M{"_id": m.id}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		if err := m.up(ctx, db); err != nil {
+			return err
+		}
+		_, err = applied.InsertOne(ctx, bson.M{"_id": m.id, "appliedAt": time.Now().UTC()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PendingMigrations lists the migrations not applied yet
+func (repo *MongoRepo) PendingMigrations(ctx context.Context) ([]string, error) {
+	applied := repo.client.Database(repo.dbName).Collection("migrations")
+	pending := []string{}
+	for _, m := range migrations {
+		err := applied.FindOne(ctx, bson.M{"_id": m.id}).Err()
+		if err == mongo.ErrNoDocuments {
+			pending = append(pending, m.id)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// Ping checks the primary is reachable
+func (repo *MongoRepo) Ping(ctx context.Context) error {
+	return repo.client.Ping(ctx, nil)
+}
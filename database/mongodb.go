@@ -4,15 +4,22 @@ package database
 import (
 	"context"
 
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MongoRepo struct {
 	client *mongo.Client
+	dbName string
+	// session is set on the repo handed to a WithTransaction callback
+	session mongo.Session
+	// set by AllowNonAtomicTransactions on standalone servers
+	nonAtomic bool
 }
 
-func NewMongoRepo(url string) (*MongoRepo, error) {
+func NewMongoRepo(url string, dbName string) (*MongoRepo, error) {
 	client, err := mongo.NewClient(options.Client().ApplyURI(url))
 	if err != nil {
 		return nil, err
@@ -21,9 +28,56 @@ func NewMongoRepo(url string) (*MongoRepo, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &MongoRepo{client: client}, nil
+	return &MongoRepo{client: client, dbName: dbName}, nil
+}
+
+// AllowNonAtomicTransactions lets WithTransaction run its steps one after the
+// other when the server is standalone, which rejects transactions. It tells
+// whether that is the case; only replica sets and mongos run transactions.
+func (repo *MongoRepo) AllowNonAtomicTransactions(ctx context.Context) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := repo.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	repo.nonAtomic = hello.SetName == "" && hello.Msg != "isdbgrid"
+	return repo.nonAtomic, nil
 }
 
 func (repo *MongoRepo) Close() error {
 	return repo.client.Disconnect(context.Background())
 }
+
+// WithTransaction runs fn inside a mongo transaction. The driver retries the
+// whole callback on TransientTransactionError and the commit on
+// UnknownTransactionCommitResult, so fn may be called more than once and must
+// not have side effects outside the repository.
+func (repo *MongoRepo) WithTransaction(ctx context.Context, fn func(tx repository.Repository) error) error {
+	// Already inside a transaction, join it
+	if repo.session != nil || repo.nonAtomic {
+		return fn(repo)
+	}
+	session, err := repo.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	tx := &MongoRepo{client: repo.client, dbName: repo.dbName, session: session}
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(tx)
+	})
+	return err
+}
+
+// sessionContext binds ctx to the transaction session, if any, so every
+// operation issued through a transactional repo takes part in it.
+func (repo *MongoRepo) sessionContext(ctx context.Context) context.Context {
+	if repo.session == nil {
+		return ctx
+	}
+	return mongo.NewSessionContext(ctx, repo.session)
+}
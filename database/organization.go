@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (repo *MongoRepo) InsertOrganization(ctx context.Context, organization *models.InsertOrganization) (*models.Organization, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("organizations")
+	result, err := collection.InsertOne(ctx, organization)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Organization{
+		Id:        result.InsertedID.(primitive.ObjectID),
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt,
+	}, nil
+}
+
+func (repo *MongoRepo) GetOrganizationById(ctx context.Context, id string) (*models.Organization, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("organizations")
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := scopeOrganizations(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return nil, err
+	}
+	var organization models.Organization
+	err = collection.FindOne(ctx, filter).Decode(&organization)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func (repo *MongoRepo) ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("organizations")
+	filter, err := scopeOrganizations(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	organizations := []models.Organization{}
+	err = cursor.All(ctx, &organizations)
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// Inside an organization the only visible organization is itself
+func scopeOrganizations(ctx context.Context, filter bson.M) (bson.M, error) {
+	oid, ok, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return bson.M{"$and": bson.A{filter, bson.M{"_id": oid}}}, nil
+	}
+	return filter, nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errUnscoped = errors.New("the operation needs an organization")
+
+// scopedOrganization returns the organization ctx is scoped to, ok is false
+// when unscoped. Contexts without a scope fail with tenant.ErrNoTenantScope.
+func scopedOrganization(ctx context.Context) (oid primitive.ObjectID, ok bool, err error) {
+	organizationId, ok, err := tenant.Organization(ctx)
+	if err != nil || !ok {
+		return primitive.NilObjectID, false, err
+	}
+	oid, err = primitive.ObjectIDFromHex(organizationId)
+	if err != nil {
+		return primitive.NilObjectID, false, err
+	}
+	return oid, true, nil
+}
+
+// scopeUsers restricts a users filter to the members of the organization in ctx
+func scopeUsers(ctx context.Context, filter bson.M) (bson.M, error) {
+	oid, ok, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		filter["memberships.organizationId"] = oid
+	}
+	return filter, nil
+}
+
+// scopeInsertUser moves the roles of a user created inside an organization to its membership
+func scopeInsertUser(ctx context.Context, user *models.InsertUser) (*models.InsertUser, error) {
+	oid, ok, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	document := *user
+	if !ok {
+		if document.Memberships == nil {
+			// Stored as an empty array so the user can join organizations later
+			document.Memberships = []models.Membership{}
+		}
+		return &document, nil
+	}
+	document.Memberships = []models.Membership{{OrganizationId: oid, Roles: user.Roles}}
+	document.Roles = []string{}
+	return &document, nil
+}
+
+// userProfile builds the profile of user as seen from the organization in ctx
+func userProfile(ctx context.Context, user models.User) models.Profile {
+	// Populate profile
+	profile := models.Profile{
+		Id:     user.Id,
+		Name:   user.Name,
+		Email:  user.Email,
+		Roles:  user.Roles,
+		Locale: user.Locale,
+	}
+	// Without a scope the profile is cut down as if seen from no organization
+	organizationId, scoped, err := tenant.Organization(ctx)
+	if err == nil && !scoped {
+		profile.Memberships = user.Memberships
+		return profile
+	}
+	// Roles in an organization come from its membership, the global ones
+	// only carry the platform admin role into it
+	profile.Roles = platformRoles(user.Roles)
+	for _, membership := range user.Memberships {
+		if membership.OrganizationId.Hex() == organizationId {
+			profile.Roles = mergeRoles(profile.Roles, organizationRoles(membership.Roles))
+		}
+	}
+	return profile
+}
+
+// platformRoles keeps the platform roles of the global ones
+func platformRoles(roles []string) []string {
+	filtered := []string{}
+	for _, role := range roles {
+		if role == tenant.PlatformAdminRole {
+			filtered = append(filtered, role)
+		}
+	}
+	return filtered
+}
+
+// organizationRoles drops platform roles, an organization can't grant more than itself
+func organizationRoles(roles []string) []string {
+	filtered := []string{}
+	for _, role := range roles {
+		if role != tenant.PlatformAdminRole {
+			filtered = append(filtered, role)
+		}
+	}
+	return filtered
+}
+
+func mergeRoles(lists ...[]string) []string {
+	roles := []string{}
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, role := range list {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
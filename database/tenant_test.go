@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+)
+
+// TestUnscopedContextFails checks that a context nobody scoped never reaches
+// the database, the scope is checked before any query is sent.
+func TestUnscopedContextFails(t *testing.T) {
+	repo, err := NewMongoRepo("mongodb://localhost:27017/", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"ListUsers", func(ctx context.Context) error {
+			_, err := repo.ListUsers(ctx)
+			return err
+		}},
+		{"SearchUsers", func(ctx context.Context) error {
+			_, _, err := repo.SearchUsers(ctx, models.UserFilter{})
+			return err
+		}},
+		{"GetUserByEmail", func(ctx context.Context) error {
+			_, err := repo.GetUserByEmail(ctx, "someone@example.com")
+			return err
+		}},
+		{"ListGroups", func(ctx context.Context) error {
+			_, err := repo.ListGroups(ctx)
+			return err
+		}},
+		{"ListAuditEntries", func(ctx context.Context) error {
+			_, err := repo.ListAuditEntries(ctx, models.AuditFilter{})
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(context.Background()); !errors.Is(err, tenant.ErrNoTenantScope) {
+				t.Errorf("%s with context.Background() returned %v, want %v", test.name, err, tenant.ErrNoTenantScope)
+			}
+		})
+	}
+}
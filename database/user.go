@@ -2,9 +2,12 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 
 	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,11 +15,16 @@ import (
 )
 
 func (repo *MongoRepo) InsertUser(ctx context.Context, user *models.InsertUser) (profile *models.Profile, err error) {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	user, err = scopeInsertUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
 	result, err := collection.InsertOne(ctx, user)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return nil, fmt.Errorf("email already exists: %v", err)
+			return nil, fmt.Errorf("email already exists: %w", err)
 		}
 		return nil, err
 	}
@@ -28,32 +36,90 @@ func (repo *MongoRepo) InsertUser(ctx context.Context, user *models.InsertUser)
 	return profile, nil
 }
 
+func (repo *MongoRepo) InsertUsers(ctx context.Context, users []*models.InsertUser) ([]models.Profile, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	documents := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		document, err := scopeInsertUser(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	// Ordered, so the users before a failing one are known to be inserted
+	result, err := collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(true))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 && result != nil {
+		failed := bulkErr.WriteErrors[0].Index
+		return nil, &repository.InsertUsersError{
+			Inserted: insertedProfiles(ctx, documents[:failed], result.InsertedIDs[:failed]),
+			Index:    failed,
+			// The server aborts a transaction on any write error
+			Aborted: repo.session != nil,
+			Err:     err,
+		}
+	}
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("email already exists: %w", err)
+		}
+		return nil, err
+	}
+	return insertedProfiles(ctx, documents, result.InsertedIDs), nil
+}
+
+func insertedProfiles(ctx context.Context, documents []interface{}, ids []interface{}) []models.Profile {
+	profiles := []models.Profile{}
+	for i, id := range ids {
+		document := documents[i].(*models.InsertUser)
+		profiles = append(profiles, userProfile(ctx, models.User{
+			Id:          id.(primitive.ObjectID),
+			Name:        document.Name,
+			Email:       document.Email,
+			Roles:       document.Roles,
+			Memberships: document.Memberships,
+			Locale:      document.Locale,
+		}))
+	}
+	return profiles
+}
+
 func (repo *MongoRepo) GetUserById(ctx context.Context, id string) (*models.Profile, error) {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
 	var user models.User
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
+	filter, err := scopeUsers(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return nil, err
+	}
 	// Find one and populate company
-	err = collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&user)
+	err = collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
-	// Populate profile
-	var profile = models.Profile{
-		Id:    user.Id,
-		Name:  user.Name,
-		Email: user.Email,
-		Roles: user.Roles,
+	profile := userProfile(ctx, user)
+	groups, err := repo.effectiveGroups(ctx, []primitive.ObjectID{user.Id})
+	if err != nil {
+		return nil, err
 	}
+	applyGroups(&profile, groups)
 	return &profile, nil
 }
 
 func (repo *MongoRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
 	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	filter, err := scopeUsers(ctx, bson.M{"email": email})
+	if err != nil {
+		return nil, err
+	}
+	err = collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
@@ -61,8 +127,13 @@ func (repo *MongoRepo) GetUserByEmail(ctx context.Context, email string) (*model
 }
 
 func (repo *MongoRepo) ListUsers(ctx context.Context) ([]models.Profile, error) {
-	collection := repo.client.Database("StockWiseWorks").Collection("users")
-	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": -1}))
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	filter, err := scopeUsers(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}))
 	if err != nil {
 		return nil, err
 	}
@@ -71,43 +142,131 @@ func (repo *MongoRepo) ListUsers(ctx context.Context) ([]models.Profile, error)
 	if err != nil {
 		return nil, err
 	}
-	profiles := []models.Profile{}
+	groups, err := repo.effectiveGroups(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	profiles := []models.Profile{}
 	for _, user := range users {
-		// Populate profile
-		var profile = models.Profile{
-			Id:    user.Id,
-			Name:  user.Name,
-			Email: user.Email,
-			Roles: user.Roles,
-		}
+		profile := userProfile(ctx, user)
+		applyGroups(&profile, groups)
 		profiles = append(profiles, profile)
 	}
 	return profiles, nil
 }
 
+// SearchUsers matches the query against names and emails, case insensitive, newest first
+func (repo *MongoRepo) SearchUsers(ctx context.Context, filter models.UserFilter) ([]models.Profile, int64, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	query := bson.M{}
+	if filter.Query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"}
+		query["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"email": pattern}}
+	}
+	query, err := scopeUsers(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip(filter.Skip)
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	userIds := []primitive.ObjectID{}
+	for _, user := range users {
+		userIds = append(userIds, user.Id)
+	}
+	groups, err := repo.effectiveGroups(ctx, userIds)
+	if err != nil {
+		return nil, 0, err
+	}
+	profiles := []models.Profile{}
+	for _, user := range users {
+		profile := userProfile(ctx, user)
+		applyGroups(&profile, groups)
+		profiles = append(profiles, profile)
+	}
+	return profiles, total, nil
+}
+
+// EachUser streams every user, newest first, without loading them all in memory
+func (repo *MongoRepo) EachUser(ctx context.Context, fn func(profile models.Profile) error) error {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	filter, err := scopeUsers(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	groups, err := repo.effectiveGroups(ctx, nil)
+	if err != nil {
+		return err
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		profile := userProfile(ctx, user)
+		applyGroups(&profile, groups)
+		if err := fn(profile); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
 func (repo *MongoRepo) UpdateUser(ctx context.Context, data models.UpdateUser) (*models.Profile, error) {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
 	oid, err := primitive.ObjectIDFromHex(data.Id)
 	if err != nil {
 		return nil, err
 	}
-	update := bson.M{
-		"$set": bson.M{},
+	filter, err := scopeUsers(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return nil, err
 	}
-	iterableData := map[string]interface{}{
-		"name":  data.Name,
-		"email": data.Email,
-		"roles": data.Roles,
+	set := bson.M{}
+	iterableData := map[string]string{
+		"name":   data.Name,
+		"email":  data.Email,
+		"locale": data.Locale,
 	}
 	for key, value := range iterableData {
-		if value != "" || value == nil {
-			update["$set"].(bson.M)[key] = value
+		if value != "" {
+			set[key] = value
+		}
+	}
+	if data.Roles != nil {
+		// Inside an organization only the roles of that membership change
+		if _, scoped := filter["memberships.organizationId"]; scoped {
+			set["memberships.$.roles"] = data.Roles
+		} else {
+			set["roles"] = data.Roles
 		}
 	}
-	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": oid}, update).Err()
+	// Mongo rejects an empty $set, nothing changes
+	if len(set) == 0 {
+		return repo.GetUserById(ctx, data.Id)
+	}
+	err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}).Err()
 	if err != nil {
 		return nil, err
 	}
@@ -117,28 +276,82 @@ func (repo *MongoRepo) UpdateUser(ctx context.Context, data models.UpdateUser) (
 	}
 	return profile, nil
 }
+
+// DeleteUser removes the user from the organization in ctx, the document is
+// only deleted once the user doesn't belong to any organization.
 func (repo *MongoRepo) DeleteUser(ctx context.Context, id string) error {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
-	_, err = collection.DeleteOne(ctx, bson.M{"_id": oid})
+	organizationId, scoped, err := scopedOrganization(ctx)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := repo.removeFromGroups(ctx, oid); err != nil {
+		return err
+	}
+	if !scoped {
+		_, err = collection.DeleteOne(ctx, bson.M{"_id": oid})
+		return err
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": oid, "memberships.organizationId": organizationId},
+		bson.M{"$pull": bson.M{"memberships": bson.M{"organizationId": organizationId}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": oid, "memberships": bson.M{"$size": 0}})
+	return err
+}
+
+// JoinOrganization adds an existing user to the organization in ctx with the given roles
+func (repo *MongoRepo) JoinOrganization(ctx context.Context, userId string, roles []string) (*models.Profile, error) {
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
+	oid, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return nil, err
+	}
+	organizationId, scoped, err := scopedOrganization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !scoped {
+		return nil, errUnscoped
+	}
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": oid, "memberships.organizationId": bson.M{"$ne": organizationId}},
+		bson.M{"$push": bson.M{"memberships": models.Membership{OrganizationId: organizationId, Roles: roles}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("user %s is already a member", userId)
+	}
+	return repo.GetUserById(ctx, userId)
 }
 
 func (repo *MongoRepo) UpdateUserPassword(ctx context.Context, userId string, newPassword string) (profile *models.Profile, err error) {
-	collection := repo.client.Database("[db-name]").Collection("users")
+	ctx = repo.sessionContext(ctx)
+	collection := repo.client.Database(repo.dbName).Collection("users")
 
 	oid, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		return nil, err
 	}
 
-	filter := bson.M{"_id": oid}
+	filter, err := scopeUsers(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return nil, err
+	}
 	update := bson.M{"$set": bson.M{"password": newPassword}}
 	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
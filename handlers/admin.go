@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/validation"
+	"github.com/danielgz405/template-api-rest-go/websocket"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users listed per page of the admin console
+const adminPageSize = 20
+
+// adminPage is the data of every admin template, each page uses the fields it needs
+type adminPage struct {
+	Locale      string
+	CSRF        string
+	Admin       *models.Profile
+	Error       string
+	FieldErrors []responses.FieldError
+	Form        adminForm
+
+	Users []models.Profile
+	Query string
+	Total int64
+	Page  int
+	Pages int
+
+	User            *models.Profile
+	AssignableRoles []string
+	Locales         []string
+
+	Filter  models.AuditFilter
+	Entries []models.AuditEntry
+
+	Clients []websocket.ClientInfo
+}
+
+// adminForm keeps what was typed when a form is shown again
+type adminForm struct {
+	Email        string
+	Name         string
+	Organization string
+	Locale       string
+	Roles        []string
+}
+
+// adminHandler lets signed in admins through and checks the CSRF token of
+// every post, anyone else is sent to the login page.
+func adminHandler(s server.Server, handle func(w http.ResponseWriter, r *http.Request, page adminPage)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := adminSession(s, r)
+		if admin == nil {
+			http.Redirect(w, r, adminPath+"/login", http.StatusSeeOther)
+			return
+		}
+		page, ok := newAdminPage(s, w, r)
+		if !ok {
+			return
+		}
+		page.Admin = admin
+		handle(w, r, page)
+	}
+}
+
+// newAdminPage reads the posted form, rejecting it without a valid CSRF token
+func newAdminPage(s server.Server, w http.ResponseWriter, r *http.Request) (adminPage, bool) {
+	page := adminPage{
+		Locale: i18n.Locale(r.Context()),
+		CSRF:   middleware.CSRFToken(s, w, r),
+	}
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, s.Config().MaxBodySize)
+		if err := r.ParseForm(); err != nil || !middleware.ValidCSRF(r, r.PostFormValue(adminCSRFField)) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(i18n.T(page.Locale, "The form expired, go back and try again")))
+			return page, false
+		}
+	}
+	return page, true
+}
+
+func renderAdmin(s server.Server, w http.ResponseWriter, r *http.Request, status int, name string, page adminPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", page.Locale)
+	// Pages show personal data and carry the CSRF token
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := s.Pages().Render(w, name, page); err != nil {
+		s.Logger().ErrorContext(r.Context(), "Error rendering an admin page", "page", name, "error", err)
+	}
+}
+
+func AdminLoginPageHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminSession(s, r) != nil {
+			http.Redirect(w, r, adminPath+"/users", http.StatusSeeOther)
+			return
+		}
+		page, _ := newAdminPage(s, w, r)
+		renderAdmin(s, w, r, http.StatusOK, "admin_login", page)
+	}
+}
+
+func AdminLoginHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, ok := newAdminPage(s, w, r)
+		if !ok {
+			return
+		}
+		req := structures.LoginRequest{
+			Email:        r.PostFormValue("email"),
+			Password:     r.PostFormValue("password"),
+			Organization: r.PostFormValue("organization"),
+		}
+		page.Form = adminForm{Email: req.Email, Organization: req.Organization}
+
+		// Only admins may use the console, whatever the credentials
+		token, err := login(s, r, req, []string{middleware.Admin})
+		switch {
+		case err == nil:
+			middleware.SetSession(s, w, token)
+			http.Redirect(w, r, adminPath+"/users", http.StatusSeeOther)
+		case errors.Is(err, errInvalidCredentials), errors.Is(err, errInvalidOrganization):
+			page.Error = i18n.T(page.Locale, "Invalid credentials")
+			renderAdmin(s, w, r, http.StatusUnauthorized, "admin_login", page)
+		default:
+			s.Logger().ErrorContext(r.Context(), "Error signing in to the admin console", "error", err)
+			page.Error = i18n.T(page.Locale, "Error signing in")
+			renderAdmin(s, w, r, http.StatusInternalServerError, "admin_login", page)
+		}
+	}
+}
+
+func AdminLogoutHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		middleware.ClearSession(s, w)
+		http.Redirect(w, r, adminPath+"/login", http.StatusSeeOther)
+	})
+}
+
+func AdminUsersHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		renderUsers(s, w, r, http.StatusOK, page)
+	})
+}
+
+func AdminNewUserHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		renderUserForm(s, w, r, http.StatusOK, page)
+	})
+}
+
+func AdminCreateUserHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		page.Form = postedUserForm(r)
+		req := structures.CreateRequest{
+			Email:    page.Form.Email,
+			Password: r.PostFormValue("password"),
+			Name:     page.Form.Name,
+			Roles:    page.Form.Roles,
+			Locale:   page.Form.Locale,
+		}
+		if page.FieldErrors = validation.Struct(req); len(page.FieldErrors) > 0 {
+			renderUserForm(s, w, r, http.StatusUnprocessableEntity, page)
+			return
+		}
+
+		_, err := createUser(s, r, page.Admin, req)
+		if errors.Is(err, errUserExists) {
+			page.Error = i18n.T(page.Locale, "User already exists")
+			renderUserForm(s, w, r, http.StatusConflict, page)
+			return
+		}
+		if err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error creating user", "error", err)
+			page.Error = i18n.T(page.Locale, "Error creating user")
+			renderUserForm(s, w, r, http.StatusInternalServerError, page)
+			return
+		}
+		http.Redirect(w, r, adminPath+"/users", http.StatusSeeOther)
+	})
+}
+
+func AdminEditUserHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		user, err := repository.GetUserById(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		roles, err := storedRoles(r, user)
+		if err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error getting user", "error", err)
+			http.Error(w, i18n.T(page.Locale, "Error getting user"), http.StatusInternalServerError)
+			return
+		}
+		page.User = user
+		page.Form = adminForm{Name: user.Name, Locale: user.Locale, Roles: roles}
+		renderUserForm(s, w, r, http.StatusOK, page)
+	})
+}
+
+func AdminUpdateUserHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		user, err := repository.GetUserById(r.Context(), mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		page.User = user
+		page.Form = postedUserForm(r)
+		req := structures.UpdateUserRequest{
+			Name:   page.Form.Name,
+			Roles:  page.Form.Roles,
+			Locale: page.Form.Locale,
+		}
+		// Unchecking every role removes them, nil would leave them as they are
+		if req.Roles == nil {
+			req.Roles = []string{}
+		}
+		// The form only offers assignable roles, others such as platform admin
+		// are kept. Roles granted by groups stay with the groups.
+		roles, err := storedRoles(r, user)
+		if err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error getting user", "error", err)
+			page.Error = i18n.T(page.Locale, "Error updating user")
+			renderUserForm(s, w, r, http.StatusInternalServerError, page)
+			return
+		}
+		for _, role := range roles {
+			if !slices.Contains(middleware.AssignableRoles, role) {
+				req.Roles = append(req.Roles, role)
+			}
+		}
+		if page.FieldErrors = validation.Struct(structures.UpdateUserRequest{Name: req.Name, Roles: page.Form.Roles, Locale: req.Locale}); len(page.FieldErrors) > 0 {
+			renderUserForm(s, w, r, http.StatusUnprocessableEntity, page)
+			return
+		}
+
+		if _, err := updateUser(s, r, page.Admin, user.Id.Hex(), req); err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error updating user", "error", err)
+			page.Error = i18n.T(page.Locale, "Error updating user")
+			renderUserForm(s, w, r, http.StatusInternalServerError, page)
+			return
+		}
+		http.Redirect(w, r, adminPath+"/users", http.StatusSeeOther)
+	})
+}
+
+func AdminDeleteUserHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		err := deleteUser(s, r, page.Admin, mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+			page.Error = i18n.T(page.Locale, "User not found")
+			renderUsers(s, w, r, http.StatusNotFound, page)
+		case err != nil:
+			s.Logger().ErrorContext(r.Context(), "Error deleting user", "error", err)
+			page.Error = i18n.T(page.Locale, "Error deleting user")
+			renderUsers(s, w, r, http.StatusInternalServerError, page)
+		default:
+			http.Redirect(w, r, adminPath+"/users", http.StatusSeeOther)
+		}
+	})
+}
+
+func AdminAuditHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		filter, err := auditFilterFromQuery(r)
+		if err != nil {
+			page.Error = i18n.T(page.Locale, "Invalid audit filter")
+		}
+		if filter.Limit <= 0 || filter.Limit > defaultAuditLimit {
+			filter.Limit = defaultAuditLimit
+		}
+		page.Filter = filter
+		page.Entries, err = repository.ListAuditEntries(r.Context(), filter)
+		if err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error getting audit log", "error", err)
+			page.Error = i18n.T(page.Locale, "Error getting audit log")
+		}
+		renderAdmin(s, w, r, http.StatusOK, "admin_audit", page)
+	})
+}
+
+func AdminClientsHandler(s server.Server) http.HandlerFunc {
+	return adminHandler(s, func(w http.ResponseWriter, r *http.Request, page adminPage) {
+		page.Clients = s.Hub().Clients(r.Context())
+		renderAdmin(s, w, r, http.StatusOK, "admin_clients", page)
+	})
+}
+
+// storedRoles reads the roles stored for the user in the organization of
+// the request, the profile also holds the ones granted by groups
+func storedRoles(r *http.Request, profile *models.Profile) ([]string, error) {
+	user, err := repository.GetUserByEmail(r.Context(), profile.Email)
+	if err != nil {
+		return nil, err
+	}
+	organizationId, scoped, err := tenant.Organization(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	if !scoped {
+		return user.Roles, nil
+	}
+	for _, membership := range user.Memberships {
+		if membership.OrganizationId.Hex() == organizationId {
+			return membership.Roles, nil
+		}
+	}
+	return []string{}, nil
+}
+
+// renderUsers lists a page of the users matching the query, an error already
+// on page is shown above them
+func renderUsers(s server.Server, w http.ResponseWriter, r *http.Request, status int, page adminPage) {
+	page.Query = r.URL.Query().Get("q")
+	page.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	page.Page = max(page.Page, 1)
+
+	users, total, err := repository.SearchUsers(r.Context(), models.UserFilter{
+		Query: page.Query,
+		Skip:  int64((page.Page - 1) * adminPageSize),
+		Limit: adminPageSize,
+	})
+	if err != nil {
+		s.Logger().ErrorContext(r.Context(), "Error getting users", "error", err)
+		page.Error = i18n.T(page.Locale, "Error getting users")
+	}
+	page.Users = users
+	page.Total = total
+	page.Pages = max(int((total+adminPageSize-1)/adminPageSize), 1)
+	renderAdmin(s, w, r, status, "admin_users", page)
+}
+
+func postedUserForm(r *http.Request) adminForm {
+	return adminForm{
+		Email:  r.PostFormValue("email"),
+		Name:   r.PostFormValue("name"),
+		Locale: r.PostFormValue("locale"),
+		Roles:  r.PostForm["roles"],
+	}
+}
+
+func renderUserForm(s server.Server, w http.ResponseWriter, r *http.Request, status int, page adminPage) {
+	page.AssignableRoles = middleware.AssignableRoles
+	page.Locales = i18n.Supported()
+	for i := range page.FieldErrors {
+		page.FieldErrors[i] = page.FieldErrors[i].Translate(page.Locale)
+	}
+	renderAdmin(s, w, r, status, "admin_user", page)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/server"
+)
+
+// The admin console shares the cookie session of browser clients, see the
+// middleware package. Its forms repeat the csrf token in the csrf field.
+const (
+	adminCSRFField = "csrf"
+	adminPath      = "/admin"
+)
+
+// adminSession returns the admin signed in to the console, or nil
+func adminSession(s server.Server, r *http.Request) *models.Profile {
+	token := middleware.SessionToken(s, r)
+	if token == "" {
+		return nil
+	}
+	profile, err := middleware.Authenticate(s, r, token)
+	if err != nil || !middleware.WaValidateRoles([]string{middleware.Admin}, profile.Roles) {
+		return nil
+	}
+	return profile
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/logging"
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+)
+
+const (
+	defaultAuditLimit = 100
+	// Larger limits are lowered to this one
+	maxAuditLimit = 1000
+)
+
+func ListAuditHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		filter, err := auditFilterFromQuery(r)
+		if err != nil {
+			responses.Error(w, r, responses.CodeInvalidQuery, "Invalid audit filter")
+			return
+		}
+		if filter.Limit == 0 {
+			filter.Limit = defaultAuditLimit
+		}
+		entries, err := repository.ListAuditEntries(r.Context(), filter)
+		if err != nil {
+			repositoryError(s, w, r, err, "Error getting audit log")
+			return
+		}
+
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+func ExportAuditHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "csv" && format != "ndjson" {
+			responses.Error(w, r, responses.CodeInvalidQuery, "Unsupported export format")
+			return
+		}
+		filter, err := auditFilterFromQuery(r)
+		if err != nil {
+			responses.Error(w, r, responses.CodeInvalidQuery, "Invalid audit filter")
+			return
+		}
+		entries, err := repository.ListAuditEntries(r.Context(), filter)
+		if err != nil {
+			repositoryError(s, w, r, err, "Error getting audit log")
+			return
+		}
+
+		switch format {
+		case "", "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+			writer := csv.NewWriter(w)
+			writer.Write([]string{"_id", "createdAt", "actorId", "actorName", "action", "targetId", "ip", "userAgent", "requestId", "diff"})
+			for _, entry := range entries {
+				diff, _ := json.Marshal(entry.Diff)
+				writer.Write([]string{
+					entry.Id.Hex(),
+					entry.CreatedAt.Format(time.RFC3339),
+					entry.ActorId,
+					entry.ActorName,
+					entry.Action,
+					entry.TargetId,
+					entry.IP,
+					entry.UserAgent,
+					entry.RequestId,
+					string(diff),
+				})
+			}
+			writer.Flush()
+		case "ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+			encoder := json.NewEncoder(w)
+			for _, entry := range entries {
+				encoder.Encode(entry)
+			}
+		}
+	}
+}
+
+func auditFilterFromQuery(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		ActorId:  query.Get("actor"),
+		TargetId: query.Get("target"),
+		Action:   query.Get("action"),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, err
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, err
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil {
+			return filter, err
+		}
+		if filter.Limit <= 0 {
+			return filter, errors.New("limit must be positive")
+		}
+		filter.Limit = min(filter.Limit, maxAuditLimit)
+	}
+	return filter, nil
+}
+
+// newAuditEntry describes an action taken by actor on the request r, before
+// and after are the states of the target used to build the diff.
+func newAuditEntry(r *http.Request, actor *models.Profile, action string, targetId string, before interface{}, after interface{}) *models.AuditEntry {
+	entry := &models.AuditEntry{
+		Action:    action,
+		TargetId:  targetId,
+		Diff:      auditDiff(before, after),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestId: logging.RequestId(r.Context()),
+		CreatedAt: time.Now().UTC(),
+	}
+	if actor != nil {
+		entry.ActorId = actor.Id.Hex()
+		entry.ActorName = actor.Name
+	}
+	return entry
+}
+
+// auditDiff lists the fields whose json value differs between both states
+func auditDiff(before interface{}, after interface{}) map[string]models.AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+	diff := map[string]models.AuditChange{}
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			diff[key] = models.AuditChange{Before: value, After: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			diff[key] = models.AuditChange{Before: nil, After: value}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+func auditFields(state interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if state == nil {
+		return fields
+	}
+	if value := reflect.ValueOf(state); value.Kind() == reflect.Pointer && value.IsNil() {
+		return fields
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// clientIP is the address resolved by the server, which only believes
+// X-Forwarded-For from trusted proxies
+func clientIP(r *http.Request) string {
+	if ip := logging.ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	return logging.RemoteIP(r)
+}
@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/validation"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Separator of the roles column in csv files
+const csvRolesSeparator = "|"
+
+var exportFields = []string{"_id", "name", "email", "roles"}
+
+// importRow is a parsed line of the uploaded file, line is the position in the file
+type importRow struct {
+	line int
+	// set when the line couldn't be parsed
+	parseError string
+	structures.ImportUserRow
+}
+
+func ImportUsersHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		r.Body = http.MaxBytesReader(w, r.Body, s.Config().ImportMaxBodySize)
+		rows, err := parseImport(r)
+		if errors.Is(err, errUnsupportedImport) {
+			responses.Error(w, r, responses.CodeUnsupportedMediaType, "The content type must be text/csv or application/x-ndjson")
+			return
+		}
+		if err != nil {
+			responses.Errorf(w, r, responses.CodeInvalidBody, "Invalid import file: %s", err.Error())
+			return
+		}
+
+		// Invited rows get an email to choose their own password instead of a preset one
+		invite := r.URL.Query().Get("invite") == "true"
+		locale := i18n.Locale(r.Context())
+		result := responses.ImportResponse{
+			Total:  len(rows),
+			DryRun: r.URL.Query().Get("dry_run") == "true",
+			Errors: []responses.ImportRowError{},
+		}
+		valid := []importRow{}
+		seen := map[string]bool{}
+		for _, row := range rows {
+			if message := validateImportRow(r, row, seen, invite); message != "" {
+				result.Errors = append(result.Errors, responses.ImportRowError{Row: row.line, Email: row.Email, Message: message})
+				continue
+			}
+			valid = append(valid, row)
+		}
+
+		if !result.DryRun && invite {
+			for _, row := range valid {
+				invitation, err := createInvitation(s, r, user, row.Email, row.Roles)
+				if err != nil {
+					result.Errors = append(result.Errors, responses.ImportRowError{Row: row.line, Email: row.Email, Message: i18n.T(locale, "Error creating invitation")})
+					continue
+				}
+				result.Invited++
+				if err := sendInvitation(s, r.Context(), invitation); err != nil {
+					result.Errors = append(result.Errors, responses.ImportRowError{Row: row.line, Email: row.Email, Message: i18n.T(locale, "Invitation created but the email could not be sent")})
+				}
+			}
+		} else if !result.DryRun {
+			batchSize := s.Config().ImportBatchSize
+			for start := 0; start < len(valid); {
+				end := min(start+batchSize, len(valid))
+				batch := valid[start:end]
+				profiles, err := importBatch(s, r, user, batch)
+				result.Created += len(profiles)
+
+				//websocked
+				for _, profile := range profiles {
+					s.Hub().Broadcast(r.Context(), models.WebsocketMessage{
+						// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
+						Code:    "0000",
+						Payload: profile,
+						User:    user.Name,
+					}, []string{"admin"}, []string{"1"})
+				}
+
+				// Only the failing row is reported, the ones after it go in the next batch
+				var insertErr *repository.InsertUsersError
+				if errors.As(err, &insertErr) {
+					row := batch[insertErr.Index]
+					message := i18n.T(locale, "Error creating user")
+					if mongo.IsDuplicateKeyError(insertErr) {
+						message = i18n.T(locale, "User already exists")
+					}
+					result.Errors = append(result.Errors, responses.ImportRowError{Row: row.line, Email: row.Email, Message: message})
+					if insertErr.Aborted {
+						// The transaction took the rows before it along, they are tried again without it
+						valid = append(valid[:start+insertErr.Index:start+insertErr.Index], valid[start+insertErr.Index+1:]...)
+						continue
+					}
+					start += insertErr.Index + 1
+					continue
+				}
+				if err != nil {
+					for _, row := range batch {
+						result.Errors = append(result.Errors, responses.ImportRowError{Row: row.line, Email: row.Email, Message: i18n.T(locale, "Error creating user")})
+					}
+					s.Logger().ErrorContext(r.Context(), "Error importing users", "error", err)
+				}
+				start = end
+			}
+		}
+		result.Failed = len(result.Errors)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func ExportUsersHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		fields := exportFields
+		if selected := r.URL.Query().Get("fields"); selected != "" {
+			fields = strings.Split(selected, ",")
+			for _, field := range fields {
+				if !contains(exportFields, field) {
+					responses.Errorf(w, r, responses.CodeInvalidQuery, "Unknown export field: %s", field)
+					return
+				}
+			}
+		}
+
+		var write func(profile models.Profile) error
+		var flush func()
+		switch r.URL.Query().Get("format") {
+		case "", "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+			writer := csv.NewWriter(w)
+			writer.Write(fields)
+			write = func(profile models.Profile) error {
+				record := []string{}
+				for _, field := range fields {
+					record = append(record, exportValue(profile, field))
+				}
+				return writer.Write(record)
+			}
+			flush = writer.Flush
+		case "ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+			encoder := json.NewEncoder(w)
+			write = func(profile models.Profile) error {
+				record := map[string]interface{}{}
+				for _, field := range fields {
+					if field == "roles" {
+						record[field] = profile.Roles
+					} else {
+						record[field] = exportValue(profile, field)
+					}
+				}
+				return encoder.Encode(record)
+			}
+			flush = func() {}
+		default:
+			responses.Error(w, r, responses.CodeInvalidQuery, "Unsupported export format")
+			return
+		}
+
+		// The status is already sent, a failure can only cut the stream short
+		err = repository.EachUser(r.Context(), write)
+		flush()
+		if err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error exporting users", "error", err)
+		}
+	}
+}
+
+// parseImport reads csv or ndjson rows depending on the request content type
+var errUnsupportedImport = errors.New("content type must be text/csv or application/x-ndjson")
+
+func parseImport(r *http.Request) ([]importRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return parseImportCSV(r.Body)
+	case "application/x-ndjson", "application/ndjson":
+		return parseImportNDJSON(r.Body)
+	default:
+		return nil, errUnsupportedImport
+	}
+}
+
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("missing email column")
+	}
+	value := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := []importRow{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := importRow{line: line}
+		row.Email = value(record, "email")
+		row.Password = value(record, "password")
+		row.Name = value(record, "name")
+		if roles := value(record, "roles"); roles != "" {
+			for _, role := range strings.Split(roles, csvRolesSeparator) {
+				row.Roles = append(row.Roles, strings.TrimSpace(role))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseImportNDJSON(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	rows := []importRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		row := importRow{line: line}
+		if err := json.Unmarshal([]byte(text), &row.ImportUserRow); err != nil {
+			// Keep going, the row is reported with the rest of the errors
+			row.parseError = "Invalid json"
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// validateImportRow returns why the row can't be imported, in the locale of
+// the request, or an empty string
+func validateImportRow(r *http.Request, row importRow, seen map[string]bool, invite bool) string {
+	locale := i18n.Locale(r.Context())
+	if row.parseError != "" {
+		return i18n.T(locale, row.parseError)
+	}
+	fieldErrors := validation.Struct(row.ImportUserRow)
+	if !invite {
+		fieldErrors = append(fieldErrors, validation.Required(map[string]string{"name": row.Name, "password": row.Password})...)
+	}
+	if len(fieldErrors) > 0 {
+		messages := []string{}
+		for _, fieldError := range fieldErrors {
+			messages = append(messages, fieldError.Field+" "+fieldError.Translate(locale).Message)
+		}
+		return strings.Join(messages, "; ")
+	}
+	email := strings.ToLower(row.Email)
+	if seen[email] {
+		return i18n.T(locale, "Duplicated email in file")
+	}
+	seen[email] = true
+	// Emails are unique across organizations
+	if _, err := repository.GetUserByEmail(tenant.Unscoped(r.Context()), row.Email); err == nil {
+		return i18n.T(locale, "User already exists")
+	}
+	return ""
+}
+
+// importBatch creates the rows and their audit entries atomically. An
+// *repository.InsertUsersError tells which row couldn't be inserted, unless
+// it aborted the transaction the rows before it are kept with their audit
+// entries.
+func importBatch(s server.Server, r *http.Request, actor *models.Profile, rows []importRow) ([]models.Profile, error) {
+	users := []*models.InsertUser{}
+	for _, row := range rows {
+		hashedPassword, err := hashPassword(r.Context(), row.Password, s.Config().BcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &models.InsertUser{
+			Email:    row.Email,
+			Password: hashedPassword,
+			Name:     row.Name,
+			Roles:    row.Roles,
+		})
+	}
+
+	var profiles []models.Profile
+	var insertErr *repository.InsertUsersError
+	marks := originatedUsers{hub: s.Hub()}
+	err := repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+		profiles, insertErr = nil, nil
+		inserted, err := tx.InsertUsers(r.Context(), users)
+		if errors.As(err, &insertErr) && !insertErr.Aborted {
+			inserted = insertErr.Inserted
+		} else if err != nil {
+			return err
+		}
+		for i := range inserted {
+			marks.mark(inserted[i].Id.Hex())
+		}
+		for i := range inserted {
+			if err := tx.InsertAuditEntry(r.Context(), newAuditEntry(r, actor, models.AuditUserCreate, inserted[i].Id.Hex(), nil, &inserted[i])); err != nil {
+				return err
+			}
+		}
+		profiles = inserted
+		return nil
+	})
+	if err != nil {
+		marks.forget()
+		return nil, err
+	}
+	if insertErr != nil {
+		return profiles, insertErr
+	}
+	return profiles, nil
+}
+
+func exportValue(profile models.Profile, field string) string {
+	switch field {
+	case "_id":
+		return profile.Id.Hex()
+	case "name":
+		return profile.Name
+	case "email":
+		return profile.Email
+	case "roles":
+		return strings.Join(profile.Roles, csvRolesSeparator)
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/validation"
+)
+
+var errTrailingData = errors.New("unexpected data after the JSON body")
+
+// decodeRequest reads the JSON body into req and validates it. Unknown fields,
+// data after the JSON value and bodies over MaxBodySize are rejected. It
+// answers with the problem and returns false when req can't be used.
+func decodeRequest(s server.Server, w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.Config().MaxBodySize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(req)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errTrailingData
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.Is(err, errTrailingData):
+		responses.Error(w, r, responses.CodeInvalidBody, "Unexpected data after the JSON body")
+		return false
+	case errors.As(err, &tooLarge):
+		responses.Errorf(w, r, responses.CodeBodyTooLarge, "The body must be at most %d bytes", tooLarge.Limit)
+		return false
+	case errors.As(err, &typeError):
+		responses.ValidationError(w, r, []responses.FieldError{responses.NewFieldError(typeError.Field, "type", "must be of type %s", typeError.Type.String())})
+		return false
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		responses.ValidationError(w, r, []responses.FieldError{responses.NewFieldError(field, "unknown", "is not a known field")})
+		return false
+	default:
+		responses.Errorf(w, r, responses.CodeInvalidBody, "The body is not valid JSON: %s", err.Error())
+		return false
+	}
+
+	if fieldErrors := validation.Struct(req); len(fieldErrors) > 0 {
+		responses.ValidationError(w, r, fieldErrors)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/pages"
+	"github.com/danielgz405/template-api-rest-go/server"
+)
+
+// DocsHandler serves the API reference of the OpenAPI document at specURL,
+// rendered by the Redoc bundle of the config or by the viewer at viewerURL
+func DocsHandler(s server.Server, specURL string, viewerURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Language", i18n.Locale(r.Context()))
+
+		data := pages.DocsData{
+			Locale:          i18n.Locale(r.Context()),
+			SpecURL:         specURL,
+			ScriptURL:       s.Config().DocsScriptURL,
+			ScriptIntegrity: s.Config().DocsScriptIntegrity,
+			ViewerURL:       viewerURL,
+		}
+		if err := s.Pages().Docs(w, data); err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error rendering the docs page", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// DocsViewerHandler serves the viewer the docs page uses without Redoc
+func DocsViewerHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(pages.DocsViewer)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// repositoryError answers with the problem matching an error of the repository,
+// anything unexpected is logged and reported as an internal error.
+func repositoryError(s server.Server, w http.ResponseWriter, r *http.Request, err error, detail string) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, primitive.ErrInvalidHex):
+		responses.Error(w, r, responses.CodeNotFound, detail)
+	case mongo.IsDuplicateKeyError(err):
+		responses.Error(w, r, responses.CodeAlreadyExists, detail)
+	default:
+		s.Logger().ErrorContext(r.Context(), detail, "error", err)
+		responses.Error(w, r, responses.CodeInternal, detail)
+	}
+}
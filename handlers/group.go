@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func CreateGroupHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+
+		var req = structures.CreateGroupRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		// Groups created outside an organization are global
+		_, scoped, err := tenant.Organization(r.Context())
+		if err != nil {
+			repositoryError(s, w, r, err, "Error creating group")
+			return
+		}
+		if !scoped && len(req.Roles) > 0 {
+			responses.Error(w, r, responses.CodeInvalidBody, "Global groups can't grant roles")
+			return
+		}
+
+		// Members must be users of the same organization
+		members := []primitive.ObjectID{}
+		for _, memberId := range req.Members {
+			member, err := repository.GetUserById(r.Context(), memberId)
+			if err != nil {
+				responses.Errorf(w, r, responses.CodeInvalidBody, "Unknown member %s", memberId)
+				return
+			}
+			members = append(members, member.Id)
+		}
+
+		var group *models.Group
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			var err error
+			group, err = tx.InsertGroup(r.Context(), &models.InsertGroup{
+				Name:      req.Name,
+				Roles:     req.Roles,
+				Members:   members,
+				CreatedAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, models.AuditGroupCreate, group.Id.Hex(), nil, group))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error creating group")
+			return
+		}
+		for _, member := range group.Members {
+			s.Hub().SetGroupMember(member.Hex(), group.Id.Hex(), true)
+		}
+		broadcastGroup(s, r, user, group.Id.Hex(), group)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+func ListGroupsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		groups, err := repository.ListGroups(r.Context())
+		if err != nil {
+			repositoryError(s, w, r, err, "Error getting groups")
+			return
+		}
+
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+func UpdateGroupHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		var req = structures.UpdateGroupRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		params := mux.Vars(r)
+		data := models.UpdateGroup{
+			Id:    params["id"],
+			Name:  req.Name,
+			Roles: req.Roles,
+		}
+		var group *models.Group
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			before, err := tx.GetGroupById(r.Context(), data.Id)
+			if err != nil {
+				return err
+			}
+			if before.OrganizationId == "" && len(data.Roles) > 0 {
+				return errGlobalGroupRoles
+			}
+			group, err = tx.UpdateGroup(r.Context(), data)
+			if err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, models.AuditGroupUpdate, data.Id, before, group))
+		})
+		if errors.Is(err, errGlobalGroupRoles) {
+			responses.Error(w, r, responses.CodeInvalidBody, "Global groups can't grant roles")
+			return
+		}
+		if err != nil {
+			repositoryError(s, w, r, err, "Error updating group")
+			return
+		}
+		broadcastGroup(s, r, user, group.Id.Hex(), group)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+func DeleteGroupHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		var deleted *models.Group
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			before, err := tx.GetGroupById(r.Context(), params["id"])
+			if err != nil {
+				return err
+			}
+			deleted = before
+			if err := tx.DeleteGroup(r.Context(), params["id"]); err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, models.AuditGroupDelete, params["id"], before, nil))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error deleting group")
+			return
+		}
+		broadcastGroup(s, r, user, params["id"], params["id"])
+		for _, member := range deleted.Members {
+			s.Hub().SetGroupMember(member.Hex(), params["id"], false)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func AddGroupMemberHandler(s server.Server) http.HandlerFunc {
+	return groupMemberHandler(s, models.AuditGroupMemberAdd, true, repository.Repository.AddGroupMember)
+}
+
+func RemoveGroupMemberHandler(s server.Server) http.HandlerFunc {
+	return groupMemberHandler(s, models.AuditGroupMemberRemove, false, repository.Repository.RemoveGroupMember)
+}
+
+// groupMemberHandler adds or removes the {userId} member of the {id} group,
+// as told by member
+func groupMemberHandler(s server.Server, action string, member bool, change func(tx repository.Repository, ctx context.Context, groupId string, userId string) (*models.Group, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+
+		// Members must be users of the same organization
+		if _, err := repository.GetUserById(r.Context(), params["userId"]); err != nil {
+			responses.Error(w, r, responses.CodeNotFound, "User not found")
+			return
+		}
+
+		var group *models.Group
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			before, err := tx.GetGroupById(r.Context(), params["id"])
+			if err != nil {
+				return err
+			}
+			group, err = change(tx, r.Context(), params["id"], params["userId"])
+			if err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, action, params["id"], before, group))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error updating group members")
+			return
+		}
+		// A removed member is told before leaving, an added one after joining
+		if member {
+			s.Hub().SetGroupMember(params["userId"], params["id"], true)
+		}
+		broadcastGroup(s, r, user, params["id"], group)
+		if !member {
+			s.Hub().SetGroupMember(params["userId"], params["id"], false)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+var errGlobalGroupRoles = errors.New("global groups can't grant roles")
+
+// broadcastGroup tells the connected members of the group, in module 1, that
+// it changed
+func broadcastGroup(s server.Server, r *http.Request, actor *models.Profile, groupId string, payload interface{}) {
+	//websocked
+	s.Hub().BroadcastToGroups(r.Context(), models.WebsocketMessage{
+		// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
+		Code:    "0001",
+		Payload: payload,
+		User:    actor.Name,
+	}, []string{groupId}, []string{"1"})
+}
@@ -1,10 +1,9 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 
-	"github.com/danielgz405/template-api-rest-go/pages"
+	"github.com/danielgz405/template-api-rest-go/i18n"
 	"github.com/danielgz405/template-api-rest-go/server"
 	"github.com/gorilla/mux"
 )
@@ -16,17 +15,16 @@ type HomeResponse struct {
 
 func HomeHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Language", i18n.Locale(r.Context()))
 
 		params := mux.Vars(r)
 
-		welcomePage, err := pages.Welcome(params["name"])
-		if err != nil {
-			fmt.Println(err.Error())
+		// The page is rendered whole before anything is written
+		if err := s.Pages().Welcome(w, i18n.Locale(r.Context()), params["name"]); err != nil {
+			s.Logger().ErrorContext(r.Context(), "Error rendering the welcome page", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(welcomePage))
 	}
 }
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/mailer"
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/validation"
+	"github.com/danielgz405/template-api-rest-go/versioning"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+)
+
+// Audience of invitation tokens, keeps them from being used anywhere else
+const invitationAudience = "invitation"
+
+func CreateInvitationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+
+		var req = structures.CreateInvitationRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		existing, _ := repository.GetUserByEmail(tenant.Unscoped(r.Context()), req.Email)
+		if existing != nil && !canJoin(r, existing) {
+			responses.Error(w, r, responses.CodeAlreadyExists, "User already exists")
+			return
+		}
+
+		invitation, err := createInvitation(s, r, user, req.Email, req.Roles)
+		if err != nil {
+			repositoryError(s, w, r, err, "Error creating invitation")
+			return
+		}
+		if err := sendInvitation(s, r.Context(), invitation); err != nil {
+			responses.Error(w, r, responses.CodeMailFailed, "Invitation created but the email could not be sent")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(invitation)
+	}
+}
+
+// canJoin tells if an existing user can be invited to the organization of
+// the request, which is only possible when they aren't a member already.
+// They join when they accept the invitation.
+func canJoin(r *http.Request, existing *models.User) bool {
+	if _, scoped, err := tenant.Organization(r.Context()); err != nil || !scoped {
+		return false
+	}
+	_, err := repository.GetUserById(r.Context(), existing.Id.Hex())
+	return err != nil
+}
+
+func ListInvitationsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		pendingOnly := r.URL.Query().Get("status") == "pending"
+		invitations, err := repository.ListInvitations(r.Context(), pendingOnly)
+		if err != nil {
+			repositoryError(s, w, r, err, "Error getting invitations")
+			return
+		}
+
+		json.NewEncoder(w).Encode(invitations)
+	}
+}
+
+func ResendInvitationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		expiresAt := time.Now().UTC().Add(s.Config().InvitationTTL)
+
+		var invitation *models.Invitation
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			before, err := tx.GetInvitationById(r.Context(), params["id"])
+			if err != nil {
+				return err
+			}
+			invitation, err = tx.RenewInvitation(r.Context(), params["id"], expiresAt)
+			if err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, models.AuditInvitationResend, params["id"], before, invitation))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error resending invitation")
+			return
+		}
+		if err := sendInvitation(s, r.Context(), invitation); err != nil {
+			responses.Error(w, r, responses.CodeMailFailed, "")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(invitation)
+	}
+}
+
+func RevokeInvitationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.Admin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		params := mux.Vars(r)
+		err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+			if err := tx.RevokeInvitation(r.Context(), params["id"]); err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, user, models.AuditInvitationRevoke, params["id"], nil, nil))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error revoking invitation")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func AcceptInvitationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+
+		var req = structures.AcceptInvitationRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		invitation, err := invitationFromToken(s, r.Context(), req.Token)
+		if err != nil {
+			responses.Error(w, r, responses.CodeInvitationInvalid, "")
+			return
+		}
+
+		// The new member lives in the organization that invited them
+		ctx := tenant.Unscoped(r.Context())
+		if invitation.OrganizationId != "" {
+			ctx = tenant.WithOrganization(r.Context(), invitation.OrganizationId)
+		}
+
+		// Existing users join with their current account, the password is never replaced
+		existing, _ := repository.GetUserByEmail(tenant.Unscoped(r.Context()), invitation.Email)
+		createUser := models.InsertUser{
+			Email: invitation.Email,
+			Name:  req.Name,
+			Roles: invitation.Roles,
+		}
+		if existing == nil {
+			if fieldErrors := validation.Required(map[string]string{"name": req.Name, "password": req.Password}); len(fieldErrors) > 0 {
+				responses.ValidationError(w, r, fieldErrors)
+				return
+			}
+			// Hash password
+			hashedPassword, err := hashPassword(r.Context(), req.Password, s.Config().BcryptCost)
+			if err != nil {
+				responses.Error(w, r, responses.CodeInternal, "")
+				return
+			}
+			createUser.Password = hashedPassword
+		}
+
+		var profile *models.Profile
+		marks := originatedUsers{hub: s.Hub()}
+		err = repository.WithTransaction(ctx, func(tx repository.Repository) error {
+			if err := tx.AcceptInvitation(ctx, invitation.Id.Hex()); err != nil {
+				return err
+			}
+			var err error
+			if existing != nil {
+				profile, err = tx.JoinOrganization(ctx, existing.Id.Hex(), invitation.Roles)
+			} else {
+				profile, err = tx.InsertUser(ctx, &createUser)
+			}
+			if err != nil {
+				return err
+			}
+			marks.mark(profile.Id.Hex())
+			return tx.InsertAuditEntry(ctx, newAuditEntry(r, profile, models.AuditInvitationAccept, profile.Id.Hex(), nil, profile))
+		})
+		if err != nil {
+			marks.forget()
+			repositoryError(s, w, r, err, "Error accepting invitation")
+			return
+		}
+
+		//websocked, same event as a created user
+		neededRolesWs := []string{"admin"}
+		neededModulesWs := []string{"1"}
+		var planMessage = models.WebsocketMessage{
+			// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
+			Code:    "0000",
+			Payload: profile,
+			User:    profile.Name,
+		}
+		s.Hub().Broadcast(ctx, planMessage, neededRolesWs, neededModulesWs)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(responses.Profile(versioning.Current(r.Context()), *profile))
+	}
+}
+
+// createInvitation stores a pending invitation in the organization of the request
+func createInvitation(s server.Server, r *http.Request, actor *models.Profile, email string, roles []string) (*models.Invitation, error) {
+	now := time.Now().UTC()
+	var invitation *models.Invitation
+	err := repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+		var err error
+		invitation, err = tx.InsertInvitation(r.Context(), &models.InsertInvitation{
+			Email:     email,
+			Roles:     roles,
+			InvitedBy: actor.Id.Hex(),
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.Config().InvitationTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, actor, models.AuditInvitationCreate, invitation.Id.Hex(), nil, invitation))
+	})
+	return invitation, err
+}
+
+// sendInvitation mails the invited email a signed link valid until the invitation expires
+func sendInvitation(s server.Server, ctx context.Context, invitation *models.Invitation) error {
+	claims := models.InvitationClaims{
+		InvitationId: invitation.Id,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  invitationAudience,
+			ExpiresAt: invitation.ExpiresAt.Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.Config().JWTSecret))
+	if err != nil {
+		return err
+	}
+	link := s.Config().InvitationURL + "?token=" + url.QueryEscape(token)
+	return s.Mailer().Send(ctx, mailer.Invitation(invitation.Email, link, invitation.ExpiresAt, i18n.Locale(ctx)))
+}
+
+func invitationFromToken(s server.Server, ctx context.Context, tokenString string) (*models.Invitation, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &models.InvitationClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.Config().JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*models.InvitationClaims)
+	if !ok || !token.Valid || !claims.VerifyAudience(invitationAudience, true) {
+		return nil, errors.New("invalid invitation token")
+	}
+	invitation, err := repository.GetInvitationById(tenant.Unscoped(ctx), claims.InvitationId.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if !invitation.Pending(time.Now()) {
+		return nil, errors.New("invitation is no longer pending")
+	}
+	return invitation, nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+)
+
+func CreateOrganizationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		neededRoles := []string{middleware.PlatformAdmin}
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+
+		// Roles validation
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+
+		var req = structures.CreateOrganizationRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		// Organizations live above every tenant
+		ctx := tenant.Unscoped(r.Context())
+		var organization *models.Organization
+		err = repository.WithTransaction(ctx, func(tx repository.Repository) error {
+			var err error
+			organization, err = tx.InsertOrganization(ctx, &models.InsertOrganization{
+				Name:      req.Name,
+				CreatedAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+			return tx.InsertAuditEntry(ctx, newAuditEntry(r, user, models.AuditOrganizationCreate, organization.Id.Hex(), nil, organization))
+		})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error creating organization")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(organization)
+	}
+}
+
+func ListOrganizationsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		//Token validation
+		_, err := middleware.ValidateToken(s, w, r)
+		if err != nil {
+			return
+		}
+
+		// Handle request, members only see the organization they signed in to
+		w.Header().Set("Content-Type", "application/json")
+		organizations, err := repository.ListOrganizations(r.Context())
+		if err != nil {
+			repositoryError(s, w, r, err, "Error getting organizations")
+			return
+		}
+
+		json.NewEncoder(w).Encode(organizations)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/tracing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt is slow by design, its spans show how much of a request it takes
+
+func hashPassword(ctx context.Context, password string, cost int) (string, error) {
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	tracing.End(span, err)
+	return string(hashed), err
+}
+
+func comparePassword(ctx context.Context, hashed string, password string) error {
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	// A mismatch is an expected outcome, not a failed span
+	span.End()
+	return err
+}
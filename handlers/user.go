@@ -2,18 +2,23 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/danielgz405/template-api-rest-go/metrics"
 	"github.com/danielgz405/template-api-rest-go/middleware"
 	"github.com/danielgz405/template-api-rest-go/models"
 	"github.com/danielgz405/template-api-rest-go/repository"
 	"github.com/danielgz405/template-api-rest-go/responses"
 	"github.com/danielgz405/template-api-rest-go/server"
 	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/versioning"
+	"github.com/danielgz405/template-api-rest-go/websocket"
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func CreateUserHandler(s server.Server) http.HandlerFunc {
@@ -25,7 +30,7 @@ func CreateUserHandler(s server.Server) http.HandlerFunc {
 		user, err := middleware.ValidateToken(s, w, r)
 
 		// Roles validation
-		if err != nil || !middleware.ValidateRoles(w, neededRoles, user.Roles) {
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
 			return
 		}
 
@@ -33,50 +38,22 @@ func CreateUserHandler(s server.Server) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 
 		var req = structures.CreateRequest{}
-		err = json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			responses.BadRequest(w, "Invalid request body")
-			return
-		}
-
-		_, err = repository.GetUserByEmail(r.Context(), req.Email)
-		if err == nil {
-			responses.BadRequest(w, "User already exists")
+		if !decodeRequest(s, w, r, &req) {
 			return
 		}
 
-		// Hash password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		if err != nil {
-			responses.NoAuthResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		profile, err := createUser(s, r, user, req)
+		if errors.Is(err, errUserExists) {
+			responses.Error(w, r, responses.CodeAlreadyExists, "User already exists")
 			return
 		}
-
-		createUser := models.InsertUser{
-			Email:    req.Email,
-			Password: string(hashedPassword),
-			Name:     req.Name,
-			Roles:    req.Roles,
-		}
-		profile, err := repository.InsertUser(r.Context(), &createUser)
 		if err != nil {
-			responses.BadRequest(w, "Error creating user")
+			repositoryError(s, w, r, err, "Error creating user")
 			return
 		}
 
-		//websocked
-		neededRolesWs := []string{"admin"}
-		neededModulesWs := []string{"1"}
-		var planMessage = models.WebsocketMessage{
-			// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
-			Code:    "0000",
-			Payload: profile,
-			User:    user.Name,
-		}
-		s.Hub().Broadcast(planMessage, neededRolesWs, neededModulesWs)
-
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(profile)
+		json.NewEncoder(w).Encode(responses.Profile(versioning.Current(r.Context()), *profile))
 	}
 }
 
@@ -84,39 +61,32 @@ func LoginHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var req = structures.LoginRequest{}
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			responses.BadRequest(w, "Invalid request body")
+		if !decodeRequest(s, w, r, &req) {
 			return
 		}
 
-		user, _ := repository.GetUserByEmail(r.Context(), req.Email)
-		if user == nil {
-			responses.NoAuthResponse(w, http.StatusUnauthorized, "Invalid credentials")
+		tokenString, err := login(s, r, req, nil)
+		if errors.Is(err, errInvalidCredentials) {
+			responses.Error(w, r, responses.CodeInvalidCredentials, "")
 			return
 		}
-
-		// Compare passwords
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-			responses.NoAuthResponse(w, http.StatusUnauthorized, "Invalid credentials")
+		if errors.Is(err, errInvalidOrganization) {
+			responses.Error(w, r, responses.CodeInvalidCredentials, "Invalid organization")
 			return
 		}
-
-		// Generate token
-		claim := models.AppClaims{
-			UserId: user.Id,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
-			},
-		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-		tokenString, err := token.SignedString([]byte(s.Config().JWTSecret))
 		if err != nil {
-			responses.NoAuthResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			repositoryError(s, w, r, err, "Error signing in")
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		if req.Cookie {
+			json.NewEncoder(w).Encode(responses.LoginResponse{
+				Message:   "Welcome, you are logged in!",
+				CSRFToken: middleware.SetSession(s, w, tokenString),
+			})
+			return
+		}
 		json.NewEncoder(w).Encode(responses.LoginResponse{
 			Message: "Welcome, you are logged in!",
 			Token:   tokenString,
@@ -124,6 +94,14 @@ func LoginHandler(s server.Server) http.HandlerFunc {
 	}
 }
 
+// LogoutHandler ends a cookie session, bearer tokens simply expire
+func LogoutHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		middleware.ClearSession(s, w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func ProfileHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -137,7 +115,35 @@ func ProfileHandler(s server.Server) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 
 		// Handle request
-		json.NewEncoder(w).Encode(profile)
+		json.NewEncoder(w).Encode(responses.Profile(versioning.Current(r.Context()), *profile))
+	}
+}
+
+func UpdateProfileHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		//Token validation
+		user, err := middleware.ValidateToken(s, w, r)
+		if err != nil {
+			return
+		}
+
+		// Handle request
+		w.Header().Set("Content-Type", "application/json")
+		var req = structures.UpdateProfileRequest{}
+		if !decodeRequest(s, w, r, &req) {
+			return
+		}
+
+		// Roles stay nil so they are left as they are
+		updatedUser, err := updateUser(s, r, user, user.Id.Hex(), structures.UpdateUserRequest{Name: req.Name, Locale: req.Locale})
+		if err != nil {
+			repositoryError(s, w, r, err, "Error updating user")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(responses.Profile(versioning.Current(r.Context()), *updatedUser))
 	}
 }
 
@@ -150,7 +156,7 @@ func ListUsersHandler(s server.Server) http.HandlerFunc {
 		user, err := middleware.ValidateToken(s, w, r)
 
 		// Roles validation
-		if err != nil || !middleware.ValidateRoles(w, neededRoles, user.Roles) {
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
 			return
 		}
 
@@ -158,11 +164,11 @@ func ListUsersHandler(s server.Server) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 		profiles, err := repository.ListUsers(r.Context())
 		if err != nil {
-			responses.NotFound(w, "Error getting users")
+			repositoryError(s, w, r, err, "Error getting users")
 			return
 		}
 
-		json.NewEncoder(w).Encode(profiles)
+		json.NewEncoder(w).Encode(responses.Profiles(versioning.Current(r.Context()), profiles))
 	}
 }
 
@@ -174,44 +180,25 @@ func UpdateAnyUserHandler(s server.Server) http.HandlerFunc {
 		user, err := middleware.ValidateToken(s, w, r)
 
 		// Roles validation
-		if err != nil || !middleware.ValidateRoles(w, neededRoles, user.Roles) {
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
 			return
 		}
 
 		// Handle request
 		w.Header().Set("Content-Type", "application/json")
 		var req = structures.UpdateUserRequest{}
-		err = json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			responses.BadRequest(w, "Invalid request body")
+		if !decodeRequest(s, w, r, &req) {
 			return
 		}
 
-		params := mux.Vars(r)
-		data := models.UpdateUser{
-			Id:    params["id"],
-			Name:  req.Name,
-			Roles: req.Roles,
-		}
-		updatedUser, err := repository.UpdateUser(r.Context(), data)
+		updatedUser, err := updateUser(s, r, user, mux.Vars(r)["id"], req)
 		if err != nil {
-			responses.BadRequest(w, "Error updating user")
+			repositoryError(s, w, r, err, "Error updating user")
 			return
 		}
 
-		//websocked
-		neededRolesWs := []string{"admin"}
-		neededModulesWs := []string{"1"}
-		var planMessage = models.WebsocketMessage{
-			// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
-			Code:    "0000",
-			Payload: updatedUser,
-			User:    user.Name,
-		}
-		s.Hub().Broadcast(planMessage, neededRolesWs, neededModulesWs)
-
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(updatedUser)
+		json.NewEncoder(w).Encode(responses.Profile(versioning.Current(r.Context()), *updatedUser))
 	}
 }
 
@@ -223,30 +210,257 @@ func DeleteUserHandler(s server.Server) http.HandlerFunc {
 		user, err := middleware.ValidateToken(s, w, r)
 
 		// Roles validation
-		if err != nil || !middleware.ValidateRoles(w, neededRoles, user.Roles) {
+		if err != nil || !middleware.ValidateRoles(w, r, neededRoles, user.Roles) {
 			return
 		}
 
 		// Handle request
 		w.Header().Set("Content-Type", "application/json")
-		params := mux.Vars(r)
-		err = repository.DeleteUser(r.Context(), params["id"])
-		if err != nil {
-			responses.BadRequest(w, "Error deleting user")
+		if err := deleteUser(s, r, user, mux.Vars(r)["id"]); err != nil {
+			repositoryError(s, w, r, err, "Error deleting user")
 			return
 		}
 
-		//websocked
-		neededRolesWs := []string{"admin"}
-		neededModulesWs := []string{"1"}
-		var planMessage = models.WebsocketMessage{
-			// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
-			Code:    "0000",
-			Payload: params["id"],
-			User:    user.Name,
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+var (
+	errUserExists          = errors.New("user already exists")
+	errInvalidCredentials  = errors.New("invalid credentials")
+	errInvalidOrganization = errors.New("invalid organization")
+)
+
+// User operations shared by the API and the admin console, they check
+// nothing about the actor, the callers validate roles first.
+
+// createUser adds a user to the organization of the request. Emails are
+// unique across organizations, a user of another organization can only join
+// by accepting an invitation, never taken over by an admin of this one.
+// Websocket clients are told about the new user.
+func createUser(s server.Server, r *http.Request, actor *models.Profile, req structures.CreateRequest) (*models.Profile, error) {
+	if existing, _ := repository.GetUserByEmail(tenant.Unscoped(r.Context()), req.Email); existing != nil {
+		return nil, errUserExists
+	}
+
+	// Hash password
+	hashedPassword, err := hashPassword(r.Context(), req.Password, s.Config().BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	createUser := models.InsertUser{
+		Email:    req.Email,
+		Password: hashedPassword,
+		Name:     req.Name,
+		Roles:    req.Roles,
+		Locale:   req.Locale,
+	}
+
+	var profile *models.Profile
+	marks := originatedUsers{hub: s.Hub()}
+	err = repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+		var err error
+		profile, err = tx.InsertUser(r.Context(), &createUser)
+		if err != nil {
+			return err
 		}
-		s.Hub().Broadcast(planMessage, neededRolesWs, neededModulesWs)
+		marks.mark(profile.Id.Hex())
+		return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, actor, models.AuditUserCreate, profile.Id.Hex(), nil, profile))
+	})
+	if err != nil {
+		marks.forget()
+		return nil, err
+	}
 
-		w.WriteHeader(http.StatusOK)
+	broadcastUser(s, r, actor, profile.Id.Hex(), profile)
+	return profile, nil
+}
+
+func updateUser(s server.Server, r *http.Request, actor *models.Profile, id string, req structures.UpdateUserRequest) (*models.Profile, error) {
+	data := models.UpdateUser{
+		Id:     id,
+		Name:   req.Name,
+		Roles:  req.Roles,
+		Locale: req.Locale,
+	}
+	var updatedUser *models.Profile
+	marks := originatedUsers{hub: s.Hub()}
+	err := repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+		before, err := tx.GetUserById(r.Context(), data.Id)
+		if err != nil {
+			return err
+		}
+		marks.mark(data.Id)
+		updated, err := tx.UpdateUser(r.Context(), data)
+		if err != nil {
+			return err
+		}
+		updatedUser = updated
+		return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, actor, models.AuditUserUpdate, data.Id, before, updatedUser))
+	})
+	if err != nil {
+		marks.forget()
+		return nil, err
+	}
+
+	broadcastUser(s, r, actor, updatedUser.Id.Hex(), updatedUser)
+	return updatedUser, nil
+}
+
+func deleteUser(s server.Server, r *http.Request, actor *models.Profile, id string) error {
+	marks := originatedUsers{hub: s.Hub()}
+	err := repository.WithTransaction(r.Context(), func(tx repository.Repository) error {
+		before, err := tx.GetUserById(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		marks.mark(id)
+		if err := tx.DeleteUser(r.Context(), id); err != nil {
+			return err
+		}
+		return tx.InsertAuditEntry(r.Context(), newAuditEntry(r, actor, models.AuditUserDelete, id, before, nil))
+	})
+	if err != nil {
+		marks.forget()
+		return err
+	}
+
+	broadcastUser(s, r, actor, id, id)
+	return nil
+}
+
+// originatedUsers marks the users a transaction writes as broadcast by the
+// handler before the transaction commits, so the watcher never sees their
+// change events first, and drops the marks when it fails
+type originatedUsers struct {
+	hub *websocket.Hub
+	ids []string
+}
+
+func (o *originatedUsers) mark(id string) {
+	o.hub.MarkOriginated("users", id)
+	o.ids = append(o.ids, id)
+}
+
+func (o *originatedUsers) forget() {
+	for _, id := range o.ids {
+		o.hub.ForgetOriginated("users", id)
+	}
+}
+
+// broadcastUser tells the admins connected to module 1 that a user changed,
+// the caller marked it as originated before writing
+func broadcastUser(s server.Server, r *http.Request, actor *models.Profile, id string, payload interface{}) {
+	//websocked
+	neededRolesWs := []string{"admin"}
+	neededModulesWs := []string{"1"}
+	var planMessage = models.WebsocketMessage{
+		// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
+		Code:    "0000",
+		Payload: payload,
+		User:    actor.Name,
+	}
+	s.Hub().Broadcast(r.Context(), planMessage, neededRolesWs, neededModulesWs)
+}
+
+// login checks the credentials and returns a token scoped to the requested
+// organization, where the user must have one of neededRoles when given.
+// Failed logins are audited, the error doesn't say why.
+func login(s server.Server, r *http.Request, req structures.LoginRequest, neededRoles []string) (string, error) {
+	user, _ := repository.GetUserByEmail(tenant.Unscoped(r.Context()), req.Email)
+	if user == nil {
+		auditLoginFailed(s, r, nil, req.Email)
+		return "", errInvalidCredentials
+	}
+
+	// Compare passwords
+	if err := comparePassword(r.Context(), user.Password, req.Password); err != nil {
+		auditLoginFailed(s, r, user, req.Email)
+		return "", errInvalidCredentials
+	}
+
+	// Organization the session is scoped to
+	organizationId, err := loginOrganization(r, user, req.Organization)
+	if err != nil {
+		auditLoginFailed(s, r, user, req.Email)
+		return "", errInvalidOrganization
+	}
+
+	// Clients restricted to some roles, like the admin console, turn the
+	// others away before the login counts as a success
+	ctx := tenant.ForUser(r.Context(), organizationId, user.Roles)
+	if len(neededRoles) > 0 {
+		profile, err := repository.GetUserById(ctx, user.Id.Hex())
+		if err != nil {
+			return "", err
+		}
+		if !middleware.WaValidateRoles(neededRoles, profile.Roles) {
+			auditLoginFailed(s, r, user, req.Email)
+			return "", errInvalidCredentials
+		}
+	}
+
+	// Generate token
+	claim := models.AppClaims{
+		UserId:         user.Id,
+		OrganizationId: organizationId,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(s.Config().TokenTTL).Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
+	tokenString, err := token.SignedString([]byte(s.Config().JWTSecret))
+	if err != nil {
+		return "", err
+	}
+
+	metrics.Logins.WithLabelValues("success").Inc()
+	actor := &models.Profile{Id: user.Id, Name: user.Name}
+	if err := repository.InsertAuditEntry(ctx, newAuditEntry(r, actor, models.AuditLogin, user.Id.Hex(), nil, nil)); err != nil {
+		s.Logger().ErrorContext(r.Context(), "Error writing audit entry", "error", err)
+	}
+	return tokenString, nil
+}
+
+// Failed logins are counted and recorded on a best effort basis, they never change the response
+func auditLoginFailed(s server.Server, r *http.Request, user *models.User, email string) {
+	metrics.Logins.WithLabelValues("failure").Inc()
+	entry := newAuditEntry(r, nil, models.AuditLoginFailed, "", nil, nil)
+	entry.ActorName = email
+	if user != nil {
+		entry.ActorId = user.Id.Hex()
+		entry.TargetId = user.Id.Hex()
+	}
+	if err := repository.InsertAuditEntry(tenant.Unscoped(r.Context()), entry); err != nil {
+		s.Logger().ErrorContext(r.Context(), "Error writing audit entry", "error", err)
+	}
+}
+
+// loginOrganization picks the organization a login is scoped to. Members get
+// the requested organization or their first one, platform admins may pick any
+// organization or none to act on all of them.
+func loginOrganization(r *http.Request, user *models.User, requested string) (primitive.ObjectID, error) {
+	platformAdmin := tenant.IsPlatformAdmin(user.Roles)
+	if requested == "" {
+		if platformAdmin || len(user.Memberships) == 0 {
+			return primitive.NilObjectID, nil
+		}
+		return user.Memberships[0].OrganizationId, nil
+	}
+	organizationId, err := primitive.ObjectIDFromHex(requested)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	for _, membership := range user.Memberships {
+		if membership.OrganizationId == organizationId {
+			return organizationId, nil
+		}
+	}
+	if platformAdmin {
+		if _, err := repository.GetOrganizationById(tenant.Unscoped(r.Context()), requested); err != nil {
+			return primitive.NilObjectID, err
+		}
+		return organizationId, nil
 	}
+	return primitive.NilObjectID, errors.New("not a member of the organization")
 }
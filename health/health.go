@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a single readiness check may take
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency can serve requests, nil means healthy
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status string `json:"status"`
+	// Logged, never served, errors may tell more than /readyz should
+	Error string `json:"-"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Registry holds the readiness checks, subsystems register their own
+type Registry struct {
+	mutex        sync.RWMutex
+	checks       map[string]Check
+	shuttingDown atomic.Bool
+	logger       *slog.Logger
+}
+
+func NewRegistry(logger *slog.Logger) *Registry {
+	return &Registry{
+		checks: make(map[string]Check),
+		logger: logger,
+	}
+}
+
+// Register adds or replaces the readiness check with the given name
+func (registry *Registry) Register(name string, check Check) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.checks[name] = check
+}
+
+// SetShuttingDown makes readiness fail so no new traffic is routed here
+func (registry *Registry) SetShuttingDown() {
+	registry.shuttingDown.Store(true)
+}
+
+// Ready runs every check concurrently
+func (registry *Registry) Ready(ctx context.Context) Report {
+	registry.mutex.RLock()
+	checks := make(map[string]Check, len(registry.checks))
+	for name, check := range registry.checks {
+		checks[name] = check
+	}
+	registry.mutex.RUnlock()
+
+	report := Report{Status: "ok", Checks: make(map[string]CheckResult, len(checks)+1)}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			result := CheckResult{Status: "ok"}
+			if err := check(checkCtx); err != nil {
+				result = CheckResult{Status: "failing", Error: err.Error()}
+				registry.logger.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[name] = result
+			if result.Error != "" {
+				report.Status = "unavailable"
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if registry.shuttingDown.Load() {
+		report.Status = "unavailable"
+		report.Checks["shutdown"] = CheckResult{Status: "failing", Error: "server is shutting down"}
+	}
+	return report
+}
+
+// LiveHandler answers as long as the process can serve http
+func (registry *Registry) LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		write(w, Report{Status: "ok"})
+	}
+}
+
+func (registry *Registry) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		write(w, registry.Ready(r.Context()))
+	}
+}
+
+func write(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,121 @@
+// Package i18n holds the message catalogs and picks the locale of a request.
+//
+// Catalogs live in locales/<locale>.json. Error titles are keyed by their
+// error code, every other message by its English text, so English needs no
+// entry and a missing translation falls back to English.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Default is the locale of the source messages
+const Default = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+type catalog struct {
+	// Error titles by error code
+	Errors map[string]string `json:"errors"`
+	// Translations by English message
+	Messages map[string]string `json:"messages"`
+}
+
+var (
+	catalogs  = map[string]catalog{}
+	supported []string
+	matcher   language.Matcher
+)
+
+func init() {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	// The default goes first, the matcher falls back to it
+	tags := []language.Tag{language.Make(Default)}
+	supported = []string{Default}
+	for _, entry := range entries {
+		locale := strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))
+		content, err := files.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var c catalog
+		if err := json.Unmarshal(content, &c); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", entry.Name(), err))
+		}
+		catalogs[locale] = c
+		if locale != Default {
+			tags = append(tags, language.Make(locale))
+			supported = append(supported, locale)
+		}
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// Supported lists the locales with a catalog, the default first
+func Supported() []string {
+	return append([]string{}, supported...)
+}
+
+// IsSupported tells if locale has a catalog
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Negotiate picks the supported locale closest to an Accept-Language header
+func Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Default
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Default
+	}
+	return supported[index]
+}
+
+// T translates message to locale and formats it with args like fmt.Sprintf
+func T(locale string, message string, args ...interface{}) string {
+	if translated, ok := catalogs[locale].Messages[message]; ok {
+		message = translated
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Title is the title of an error code in locale, fallback when it has none
+func Title(locale string, code string, fallback string) string {
+	if title, ok := catalogs[locale].Errors[code]; ok {
+		return title
+	}
+	return fallback
+}
+
+type localeKey struct{}
+
+// WithLocale sets the locale messages for ctx are written in
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// Locale of ctx, Default when none was set
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return Default
+}
@@ -0,0 +1,13 @@
+package i18n
+
+import "net/http"
+
+// Middleware sets the locale of the request from Accept-Language, a signed in
+// user's preference replaces it once the token is validated.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		ctx := WithLocale(r.Context(), Negotiate(r.Header.Get("Accept-Language")))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
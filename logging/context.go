@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	userIdKey
+)
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// RequestId returns the id the middleware assigned to the request, if any
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+// withUserSlot reserves room for the user id, authentication happens after the
+// middleware has already handed the context down
+func withUserSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userIdKey, &atomic.Value{})
+}
+
+// SetUserId records the authenticated user for the request log line
+func SetUserId(ctx context.Context, userId string) {
+	if slot, ok := ctx.Value(userIdKey).(*atomic.Value); ok {
+		slot.Store(userId)
+	}
+}
+
+func UserId(ctx context.Context) string {
+	slot, ok := ctx.Value(userIdKey).(*atomic.Value)
+	if !ok {
+		return ""
+	}
+	userId, _ := slot.Load().(string)
+	return userId
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const RequestIdHeader = "X-Request-ID"
+
+// Incoming ids are kept only when they are safe to log and echo back
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Middleware assigns every request an id, propagated from X-Request-ID when
+// the caller sent one, and logs a line per request once it's served. route
+// returns the route template so ids in paths (and tokens) stay out of the logs.
+func Middleware(logger *slog.Logger, route func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestId := r.Header.Get(RequestIdHeader)
+			if !validRequestId.MatchString(requestId) {
+				requestId = newRequestId()
+			}
+			w.Header().Set(RequestIdHeader, requestId)
+			ctx := withUserSlot(WithRequestId(r.Context(), requestId))
+			r = r.WithContext(ctx)
+			template := route(r)
+
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "request",
+				slog.String("method", r.Method),
+				slog.String("route", template),
+				slog.Int("status", status),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int64("bytes", recorder.bytes),
+				slog.Bool("hijacked", recorder.hijacked),
+			)
+		})
+	}
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// responseRecorder keeps the status and size, websockets still hijack and exports still flush through it
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes += int64(n)
+	return n, err
+}
+
+func (recorder *responseRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (recorder *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	recorder.hijacked = true
+	recorder.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (recorder *responseRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer logs messages instead of sending them, used when no SMTP server is
+// configured. Only the recipient and subject are logged, bodies carry secrets
+// like invitation links.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (m LogMailer) Send(ctx context.Context, message Message) error {
+	m.Logger.InfoContext(ctx, "Mail not sent, no smtp server configured", "to", message.To, "subject", message.Subject)
+	return nil
+}
+
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.From)
+	fmt.Fprintf(&body, "To: %s\r\n", message.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", message.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(message.Body)
+	return smtp.SendMail(m.Addr, auth, m.From, []string{message.To}, []byte(body.String()))
+}
+
+// New returns an SMTP mailer, or a LogMailer writing to logger when addr is empty
+func New(addr string, from string, username string, password string, logger *slog.Logger) Mailer {
+	if addr == "" {
+		return LogMailer{Logger: logger}
+	}
+	return &SMTPMailer{
+		Addr:     addr,
+		From:     from,
+		Username: username,
+		Password: password,
+	}
+}
@@ -0,0 +1,16 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+)
+
+func Invitation(to string, link string, expiresAt time.Time, locale string) Message {
+	return Message{
+		To:      to,
+		Subject: i18n.T(locale, "You have been invited"),
+		Body: i18n.T(locale, "You have been invited to join. Open the link below to choose your name and password:\n\n%s\n\nThe link expires on %s.\n",
+			link, expiresAt.UTC().Format("2006-01-02 15:04 MST")),
+	}
+}
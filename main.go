@@ -2,60 +2,294 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/danielgz405/template-api-rest-go/config"
 	"github.com/danielgz405/template-api-rest-go/handlers"
+	"github.com/danielgz405/template-api-rest-go/health"
+	"github.com/danielgz405/template-api-rest-go/middleware"
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/openapi"
+	"github.com/danielgz405/template-api-rest-go/responses"
 	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/structures"
+	"github.com/danielgz405/template-api-rest-go/versioning"
+	"github.com/danielgz405/template-api-rest-go/websocket"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// The .env file is a convenience for local runs, containers inject the environment
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Error loading .env file: ", err)
 	}
 
-	PORT := os.Getenv("PORT")
-	JWT_SECRET := os.Getenv("JWT_SECRET")
-	MONGO_TEST := os.Getenv("DB_URI_TEST")
-	TESTING_MODE := os.Getenv("TESTING_MODE") == "true"
-
-	DB_URI := os.Getenv("DB_URI")
-	if TESTING_MODE {
-		DB_URI = MONGO_TEST
-		fmt.Println("Ⓐ ☭------------♥♥♥ THE MODE IS TESTING ♥♥♥------------☭ Ⓐ")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
 	}
+	// SIGTERM comes from the orchestrator on rolling deploys, SIGINT from ctrl+c
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	s, err := server.NewServer(context.Background(), &server.Config{
-		Port:      ":" + PORT,
-		JWTSecret: JWT_SECRET,
-		DbURI:     DB_URI,
-	})
+	s, err := server.NewServer(ctx, cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Packages without an injected logger, and the log package, write through it too
+	slog.SetDefault(s.Logger())
+	if cfg.TestingMode {
+		s.Logger().Warn("Ⓐ ☭------------♥♥♥ THE MODE IS TESTING ♥♥♥------------☭ Ⓐ")
+	}
+	s.Logger().Info("Configuration loaded", "config", cfg)
+
+	// Changes made outside the handlers (scripts, other services) reach the same clients
+	s.Watch(websocket.WatchTarget{
+		Collection: "users",
+		Code:       "0000",
+		Roles:      []string{middleware.Admin},
+		Modules:    []string{"1"},
+	})
 
-	s.Start(BindRoutes)
+	if err := s.Start(ctx, BindRoutes); err != nil {
+		s.Logger().Error("Server stopped with an error", "error", err)
+		os.Exit(1)
+	}
 
 }
 
 func BindRoutes(s server.Server, r *mux.Router) {
-	r.HandleFunc("/welcome/{name}", handlers.HomeHandler(s)).Methods(http.MethodGet)
+	// Routes of the API are registered through the spec, which documents them at /openapi.json
+	api := openapi.New("template-api-rest-go", "1.0.0", s.Config().SessionCookieName)
+	// v2 was released, v1 only changes to fix bugs until its sunset. Both
+	// dates were validated with the config, empty ones stay zero.
+	deprecated, _ := time.Parse(time.DateOnly, s.Config().V1Deprecated)
+	sunset, _ := time.Parse(time.DateOnly, s.Config().V1Sunset)
+	v1 := versioning.Version{Name: versioning.V1, Deprecated: deprecated, Sunset: sunset, Successor: versioning.V2}
+	v2 := versioning.Version{Name: versioning.V2}
+
+	api.HandleFunc(r, http.MethodGet, "/welcome/{name}", handlers.HomeHandler(s), openapi.Operation{
+		Summary: "Welcome page", Tags: []string{"pages"}, Public: true,
+		ResponseTypes: []string{"text/html"},
+	})
+
+	//Docs
+	api.HandleFunc(r, http.MethodGet, "/openapi.json", api.Handler(), openapi.Operation{
+		Summary: "This OpenAPI document", Tags: []string{"docs"}, Public: true,
+		Response: map[string]interface{}{},
+	})
+	api.HandleFunc(r, http.MethodGet, "/docs", handlers.DocsHandler(s, "/openapi.json", "/docs/viewer.js"), openapi.Operation{
+		Summary: "API reference", Tags: []string{"docs"}, Public: true,
+		ResponseTypes: []string{"text/html"},
+	})
+	api.HandleFunc(r, http.MethodGet, "/docs/viewer.js", handlers.DocsViewerHandler(s), openapi.Operation{
+		Summary: "Script rendering the API reference without Redoc", Tags: []string{"docs"}, Public: true,
+		ResponseTypes: []string{"text/javascript"},
+	})
+
+	//Health
+	api.HandleFunc(r, http.MethodGet, "/healthz", s.Health().LiveHandler(), openapi.Operation{
+		Summary: "Liveness", Tags: []string{"health"}, Public: true,
+		Response: health.Report{},
+	})
+	api.HandleFunc(r, http.MethodGet, "/readyz", s.Health().ReadyHandler(), openapi.Operation{
+		Summary: "Readiness of the database, the hub and the migrations", Tags: []string{"health"}, Public: true,
+		Response: health.Report{}, OtherResponses: map[int]interface{}{http.StatusServiceUnavailable: health.Report{}},
+	})
+
+	//API, under each version and, for clients older than versioning, without prefix
+	for _, version := range []versioning.Version{v1, v2} {
+		router := r.PathPrefix("/" + version.Name).Subrouter()
+		router.Use(version.Middleware)
+		bindAPI(s, api, router, version)
+	}
+
+	//admin console, HTML forms left out of the API document
+	r.Handle("/admin", http.RedirectHandler("/admin/users", http.StatusSeeOther)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/login", handlers.AdminLoginPageHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/login", handlers.AdminLoginHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/logout", handlers.AdminLogoutHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/users", handlers.AdminUsersHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/users", handlers.AdminCreateUserHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/users/new", handlers.AdminNewUserHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/users/{id}", handlers.AdminEditUserHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/users/{id}", handlers.AdminUpdateUserHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/users/{id}/delete", handlers.AdminDeleteUserHandler(s)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/audit", handlers.AdminAuditHandler(s)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/clients", handlers.AdminClientsHandler(s)).Methods(http.MethodGet)
+
+	//WS
+	api.HandleFunc(r, http.MethodGet, "/ws/{Authorization}/{Module}", s.Hub().HandleWebSocket(s.Config().JWTSecret), openapi.Operation{
+		Summary: "Websocket of a module", Tags: []string{"websocket"}, Public: true,
+		Description: "The login token goes in the path. Messages are models.WebsocketMessage documents.",
+		Status:      http.StatusSwitchingProtocols,
+	})
+	api.HandleFunc(r, http.MethodGet, "/ws/{Module}", s.Hub().HandleWebSocket(s.Config().JWTSecret), openapi.Operation{
+		Summary: "Websocket of a module for browsers signed in with the session cookie", Tags: []string{"websocket"},
+		Description: "The page must be served from this origin or one listed in cors_allowed_origins.",
+		Status:      http.StatusSwitchingProtocols,
+	})
+
+	// Matches nothing but the API paths, whatever their order
+	unversioned := r.NewRoute().Subrouter()
+	unversioned.Use(v1.Middleware)
+	bindAPI(s, api, unversioned, v1)
+}
+
+// bindAPI registers the API routes of version on r, documenting them
+func bindAPI(s server.Server, api *openapi.Spec, r *mux.Router, version versioning.Version) {
+	handle := func(method string, path string, h http.HandlerFunc, operation openapi.Operation) {
+		operation.Deprecated = !version.Deprecated.IsZero()
+		api.HandleFunc(r, method, path, h, operation)
+	}
+	// The representations clients of this version expect
+	profile := responses.Profile(version.Name, models.Profile{})
+	profiles := responses.Profiles(version.Name, nil)
+	admin := []string{middleware.Admin}
+	auditFilter := []openapi.Param{
+		{Name: "actor", Description: "Id of the user who acted"},
+		{Name: "target", Description: "Id of the document acted on"},
+		{Name: "action", Description: "e.g. user.create"},
+		{Name: "from", Type: "date-time"},
+		{Name: "to", Type: "date-time"},
+		{Name: "limit", Type: "integer", Description: "1 to 1000, 100 when listing"},
+	}
 
 	//Auth
-	r.HandleFunc("/login", handlers.LoginHandler(s)).Methods(http.MethodPost)
+	handle(http.MethodPost, "/login", handlers.LoginHandler(s), openapi.Operation{
+		Summary: "Sign in", Tags: []string{"auth"}, Public: true,
+		Description: "With cookie set the token goes to an HttpOnly session cookie and the response carries the csrf token instead.",
+		Body:        structures.LoginRequest{}, Response: responses.LoginResponse{},
+		Errors: []responses.ErrorCode{responses.CodeInvalidCredentials},
+	})
+	handle(http.MethodPost, "/logout", handlers.LogoutHandler(s), openapi.Operation{
+		Summary: "End a cookie session", Tags: []string{"auth"}, Public: true,
+		Status: http.StatusNoContent,
+	})
 
 	//user
-	r.HandleFunc("/user/create", handlers.CreateUserHandler(s)).Methods(http.MethodPost)
-	r.HandleFunc("/user/delete/{id}", handlers.DeleteUserHandler(s)).Methods(http.MethodDelete)
-	r.HandleFunc("/user/update/{id}", handlers.UpdateAnyUserHandler(s)).Methods(http.MethodPatch)
-	r.HandleFunc("/users/list", handlers.ListUsersHandler(s)).Methods(http.MethodGet)
-	r.HandleFunc("/user/profile", handlers.ProfileHandler(s)).Methods(http.MethodGet)
+	handle(http.MethodPost, "/user/create", handlers.CreateUserHandler(s), openapi.Operation{
+		Summary: "Create a user", Tags: []string{"users"}, Roles: admin,
+		Body: structures.CreateRequest{}, Response: profile,
+		Errors: []responses.ErrorCode{responses.CodeAlreadyExists},
+	})
+	handle(http.MethodDelete, "/user/delete/{id}", handlers.DeleteUserHandler(s), openapi.Operation{
+		Summary: "Delete a user", Tags: []string{"users"}, Roles: admin,
+	})
+	handle(http.MethodPatch, "/user/update/{id}", handlers.UpdateAnyUserHandler(s), openapi.Operation{
+		Summary: "Update a user", Tags: []string{"users"}, Roles: admin,
+		Body: structures.UpdateUserRequest{}, Response: profile,
+	})
+	handle(http.MethodGet, "/users/list", handlers.ListUsersHandler(s), openapi.Operation{
+		Summary: "List users", Tags: []string{"users"}, Roles: admin,
+		Response: profiles,
+	})
+	handle(http.MethodGet, "/user/profile", handlers.ProfileHandler(s), openapi.Operation{
+		Summary: "Profile of the signed in user", Tags: []string{"users"},
+		Response: profile,
+	})
+	handle(http.MethodPatch, "/user/profile", handlers.UpdateProfileHandler(s), openapi.Operation{
+		Summary: "Update the name and language of the signed in user", Tags: []string{"users"},
+		Body: structures.UpdateProfileRequest{}, Response: profile,
+	})
+	handle(http.MethodPost, "/users/import", handlers.ImportUsersHandler(s), openapi.Operation{
+		Summary: "Import users from csv or ndjson", Tags: []string{"users"}, Roles: admin,
+		Query: []openapi.Param{
+			{Name: "dry_run", Type: "boolean", Description: "Validate the rows without creating anything"},
+			{Name: "invite", Type: "boolean", Description: "Invite the rows by email instead of creating them"},
+		},
+		BodyTypes: []string{"text/csv", "application/x-ndjson"}, Response: responses.ImportResponse{},
+		Errors: []responses.ErrorCode{responses.CodeInvalidBody, responses.CodeUnsupportedMediaType},
+	})
+	handle(http.MethodGet, "/users/export", handlers.ExportUsersHandler(s), openapi.Operation{
+		Summary: "Export users as csv or ndjson", Tags: []string{"users"}, Roles: admin,
+		Query: []openapi.Param{
+			{Name: "format", Enum: []string{"csv", "ndjson"}},
+			{Name: "fields", Description: "Comma separated columns: _id, name, email, roles"},
+		},
+		ResponseTypes: []string{"text/csv", "application/x-ndjson"},
+		Errors:        []responses.ErrorCode{responses.CodeInvalidQuery},
+	})
 
-	//WS
-	r.HandleFunc("/ws/{Authorization}/{Module}", s.Hub().HandleWebSocket(s.Config().JWTSecret))
+	//organizations
+	handle(http.MethodPost, "/organization/create", handlers.CreateOrganizationHandler(s), openapi.Operation{
+		Summary: "Create an organization", Tags: []string{"organizations"}, Roles: []string{middleware.PlatformAdmin},
+		Body: structures.CreateOrganizationRequest{}, Response: models.Organization{},
+	})
+	handle(http.MethodGet, "/organizations/list", handlers.ListOrganizationsHandler(s), openapi.Operation{
+		Summary: "Organizations the signed in user belongs to", Tags: []string{"organizations"},
+		Response: []models.Organization{},
+	})
+
+	//groups
+	handle(http.MethodPost, "/group/create", handlers.CreateGroupHandler(s), openapi.Operation{
+		Summary: "Create a group", Tags: []string{"groups"}, Roles: admin,
+		Body: structures.CreateGroupRequest{}, Response: models.Group{},
+	})
+	handle(http.MethodGet, "/groups/list", handlers.ListGroupsHandler(s), openapi.Operation{
+		Summary: "List groups", Tags: []string{"groups"}, Roles: admin,
+		Response: []models.Group{},
+	})
+	handle(http.MethodPatch, "/group/update/{id}", handlers.UpdateGroupHandler(s), openapi.Operation{
+		Summary: "Update a group", Tags: []string{"groups"}, Roles: admin,
+		Body: structures.UpdateGroupRequest{}, Response: models.Group{},
+	})
+	handle(http.MethodDelete, "/group/delete/{id}", handlers.DeleteGroupHandler(s), openapi.Operation{
+		Summary: "Delete a group", Tags: []string{"groups"}, Roles: admin,
+	})
+	handle(http.MethodPost, "/group/member/add/{id}/{userId}", handlers.AddGroupMemberHandler(s), openapi.Operation{
+		Summary: "Add a member to a group", Tags: []string{"groups"}, Roles: admin,
+		Response: models.Group{},
+	})
+	handle(http.MethodDelete, "/group/member/remove/{id}/{userId}", handlers.RemoveGroupMemberHandler(s), openapi.Operation{
+		Summary: "Remove a member from a group", Tags: []string{"groups"}, Roles: admin,
+		Response: models.Group{},
+	})
+
+	//invitations
+	handle(http.MethodPost, "/invitation/create", handlers.CreateInvitationHandler(s), openapi.Operation{
+		Summary: "Invite a user by email", Tags: []string{"invitations"}, Roles: admin,
+		Body: structures.CreateInvitationRequest{}, Response: models.Invitation{},
+		Errors: []responses.ErrorCode{responses.CodeAlreadyExists, responses.CodeMailFailed},
+	})
+	handle(http.MethodGet, "/invitations/list", handlers.ListInvitationsHandler(s), openapi.Operation{
+		Summary: "List invitations", Tags: []string{"invitations"}, Roles: admin,
+		Query:    []openapi.Param{{Name: "status", Enum: []string{"pending"}, Description: "Only the ones not accepted, revoked or expired"}},
+		Response: []models.Invitation{},
+	})
+	handle(http.MethodPost, "/invitation/resend/{id}", handlers.ResendInvitationHandler(s), openapi.Operation{
+		Summary: "Send an invitation again", Tags: []string{"invitations"}, Roles: admin,
+		Response: models.Invitation{},
+		Errors:   []responses.ErrorCode{responses.CodeMailFailed},
+	})
+	handle(http.MethodDelete, "/invitation/revoke/{id}", handlers.RevokeInvitationHandler(s), openapi.Operation{
+		Summary: "Revoke an invitation", Tags: []string{"invitations"}, Roles: admin,
+	})
+	handle(http.MethodPost, "/invitation/accept", handlers.AcceptInvitationHandler(s), openapi.Operation{
+		Summary: "Accept an invitation", Tags: []string{"invitations"}, Public: true,
+		Body: structures.AcceptInvitationRequest{}, Response: profile,
+		Errors: []responses.ErrorCode{responses.CodeInvitationInvalid},
+	})
+
+	//audit
+	handle(http.MethodGet, "/audit/list", handlers.ListAuditHandler(s), openapi.Operation{
+		Summary: "List audit entries, newest first", Tags: []string{"audit"}, Roles: admin,
+		Query: auditFilter, Response: []models.AuditEntry{},
+		Errors: []responses.ErrorCode{responses.CodeInvalidQuery},
+	})
+	handle(http.MethodGet, "/audit/export", handlers.ExportAuditHandler(s), openapi.Operation{
+		Summary: "Export audit entries as csv or ndjson", Tags: []string{"audit"}, Roles: admin,
+		Query:         append([]openapi.Param{{Name: "format", Enum: []string{"csv", "ndjson"}}}, auditFilter...),
+		ResponseTypes: []string{"text/csv", "application/x-ndjson"},
+		Errors:        []responses.ErrorCode{responses.CodeInvalidQuery},
+	})
 }
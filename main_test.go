@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgz405/template-api-rest-go/config"
+	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPICoversRoutes fails when a route is registered without being documented
+func TestOpenAPICoversRoutes(t *testing.T) {
+	cfg := config.Default()
+	cfg.JWTSecret = "test"
+	cfg.DbURI = "mongodb://localhost:27017/"
+	cfg.DbName = "test"
+	s, err := server.NewServer(context.Background(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	BindRoutes(s, router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json answered %d", recorder.Code)
+	}
+	var document struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.OpenAPI != "3.1.0" {
+		t.Errorf("openapi is %q, want 3.1.0", document.OpenAPI)
+	}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		// Subrouters hold routes without handling anything themselves
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		// The admin console is HTML forms, not API
+		if path == "/admin" || strings.HasPrefix(path, "/admin/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("%s accepts any method, register it with one", path)
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := document.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is missing from the OpenAPI document, register it through the spec", method, path)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "api"
+
+// Registry holds every metric of the server plus the go runtime and process ones
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	APIVersionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "version_requests_total",
+		Help:      "API requests by version and path, versioned when it starts with the version or unversioned when it predates versioning.",
+	}, []string{"version", "path"})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result, success or failure.",
+	}, []string{"result"})
+
+	RepositoryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_call_duration_seconds",
+		Help:      "Repository call latency by method and result, ok or error.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method", "result"})
+
+	WebsocketClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Connected websocket clients by module.",
+	}, []string{"module"})
+
+	BroadcastRecipients = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "websocket_broadcast_recipients",
+		Help:      "Clients a websocket message was queued for.",
+		Buckets:   []float64{0, 1, 5, 10, 50, 100, 500, 1000},
+	})
+
+	BroadcastDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_dropped_total",
+		Help:      "Websocket messages dropped because the client's buffer was full.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		APIVersionRequests,
+		Logins,
+		RepositoryDuration,
+		WebsocketClients,
+		BroadcastRecipients,
+		BroadcastDropped,
+		profileCache,
+	)
+}
+
+// ProfileCacheStats are the counters of the profile cache
+type ProfileCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// SetProfileCache exposes the counters of the profile cache, stats is read
+// on every scrape. Nothing is exposed while the cache is disabled.
+func SetProfileCache(stats func() ProfileCacheStats) {
+	profileCache.mutex.Lock()
+	defer profileCache.mutex.Unlock()
+	profileCache.stats = stats
+}
+
+var profileCache = &profileCacheCollector{
+	hits:      prometheus.NewDesc(namespace+"_profile_cache_hits_total", "Profiles served from the cache.", nil, nil),
+	misses:    prometheus.NewDesc(namespace+"_profile_cache_misses_total", "Profiles not in the cache or expired.", nil, nil),
+	evictions: prometheus.NewDesc(namespace+"_profile_cache_evictions_total", "Profiles evicted because the cache was full.", nil, nil),
+	size:      prometheus.NewDesc(namespace+"_profile_cache_size", "Profiles in the cache.", nil, nil),
+}
+
+type profileCacheCollector struct {
+	mutex                         sync.Mutex
+	stats                         func() ProfileCacheStats
+	hits, misses, evictions, size *prometheus.Desc
+}
+
+func (c *profileCacheCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.hits
+	descs <- c.misses
+	descs <- c.evictions
+	descs <- c.size
+}
+
+func (c *profileCacheCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	stats := c.stats
+	c.mutex.Unlock()
+	if stats == nil {
+		return
+	}
+	current := stats()
+	metrics <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(current.Hits))
+	metrics <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(current.Misses))
+	metrics <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(current.Evictions))
+	metrics <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(current.Size))
+}
+
+// Handler serves the metrics, behind a bearer token when one is given
+func Handler(token string) http.Handler {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+type routeKey struct{}
+
+// Middleware counts and times every request by the template route returns
+func Middleware(route func(r *http.Request) string) func(next http.Handler) http.Handler {
+	routeLabel := promhttp.WithLabelFromCtx("route", func(ctx context.Context) string {
+		template, _ := ctx.Value(routeKey{}).(string)
+		return template
+	})
+	return func(next http.Handler) http.Handler {
+		instrumented := promhttp.InstrumentHandlerCounter(HTTPRequests,
+			promhttp.InstrumentHandlerDuration(HTTPDuration, next, routeLabel),
+			routeLabel,
+		)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			instrumented.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, route(r))))
+		})
+	}
+}
+
+// ObserveRepository times a repository call, see repository.Observer
+func ObserveRepository(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		RepositoryDuration.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+	}
+}
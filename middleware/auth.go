@@ -1,13 +1,17 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/logging"
 	"github.com/danielgz405/template-api-rest-go/models"
 	"github.com/danielgz405/template-api-rest-go/repository"
 	"github.com/danielgz405/template-api-rest-go/responses"
 	"github.com/danielgz405/template-api-rest-go/server"
+	"github.com/danielgz405/template-api-rest-go/tenant"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
@@ -17,7 +21,16 @@ var (
 	NO_AUTH_NEEDED = []string{
 		"/welcome",
 		"login",
+		"/logout",
 		"/verify",
+		"/invitation/accept",
+		"/healthz",
+		"/readyz",
+		"/metrics",
+		"/openapi.json",
+		"/docs",
+		// the console signs in with its own cookie
+		"/admin",
 	}
 	AUTH_BY_PARAMS = []string{
 		"ws",
@@ -54,11 +67,14 @@ func CheckAuthMiddleware(s server.Server) func(h http.Handler) http.Handler {
 				params := mux.Vars(r)
 				tokenString = strings.TrimSpace(params["Authorization"])
 			}
+			if tokenString == "" {
+				tokenString = SessionToken(s, r)
+			}
 			_, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
 				return []byte(s.Config().JWTSecret), nil
 			})
 			if err != nil {
-				responses.NoAuthResponse(w, http.StatusUnauthorized, "Expired or invalid token")
+				responses.Error(w, r, responses.CodeUnauthorized, "Expired or invalid token")
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -66,41 +82,80 @@ func CheckAuthMiddleware(s server.Server) func(h http.Handler) http.Handler {
 	}
 }
 
+// ValidateToken also scopes the request context to the organization the user
+// signed in to, every repository call made with r.Context() afterwards stays
+// inside that organization. The Authorization header wins, without it the
+// session cookie is used and changes must carry the csrf token.
 func ValidateToken(s server.Server, w http.ResponseWriter, r *http.Request) (*models.Profile, error) {
 	tokenString := strings.TrimSpace(r.Header.Get("Authorization"))
+	if tokenString == "" {
+		tokenString = SessionToken(s, r)
+		if tokenString != "" && !validCSRF(r) {
+			responses.Error(w, r, responses.CodeCSRFFailed, "The X-CSRF-Token header must repeat the csrf_token cookie")
+			return nil, errCSRF
+		}
+	}
+	profile, err := Authenticate(s, r, tokenString)
+	if err != nil {
+		responses.Error(w, r, responses.CodeUnauthorized, "Error validating token")
+		return nil, err
+	}
+	return profile, nil
+}
+
+// Authenticate checks a login token wherever it came from and scopes r like
+// ValidateToken, without answering the request when it fails.
+func Authenticate(s server.Server, r *http.Request, tokenString string) (*models.Profile, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(s.Config().JWTSecret), nil
 	})
 	if err != nil {
-		responses.NoAuthResponse(w, http.StatusUnauthorized, "Error validating token")
 		return nil, err
 	}
-	if claims, ok := token.Claims.(*models.AppClaims); ok && token.Valid {
-		userId := claims.UserId.Hex()
-		profile, err := repository.GetUserById(r.Context(), userId)
-		if err != nil {
-			responses.NoAuthResponse(w, http.StatusUnauthorized, "Error validating token")
-			return nil, err
-		}
-		return profile, nil
-	} else {
+	claims, ok := token.Claims.(*models.AppClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	userId := claims.UserId.Hex()
+	// Looking the user up inside the organization checks they still belong to it
+	lookupCtx := tenant.Unscoped(r.Context())
+	if !claims.OrganizationId.IsZero() {
+		lookupCtx = tenant.WithOrganization(r.Context(), claims.OrganizationId.Hex())
+	}
+	profile, err := repository.GetUserById(lookupCtx, userId)
+	if err != nil {
 		return nil, err
 	}
+	ctx := tenant.ForUser(r.Context(), claims.OrganizationId, profile.Roles)
+	// A stored preference wins over Accept-Language
+	if i18n.IsSupported(profile.Locale) {
+		ctx = i18n.WithLocale(ctx, profile.Locale)
+	}
+	*r = *r.WithContext(ctx)
+	logging.SetUserId(r.Context(), userId)
+	return profile, nil
 }
 
-func ValidateRoles(w http.ResponseWriter, neededRoles []string, roles []string) bool {
-	for _, r := range neededRoles {
+// Platform admins pass every role check
+func ValidateRoles(w http.ResponseWriter, r *http.Request, neededRoles []string, roles []string) bool {
+	if tenant.IsPlatformAdmin(roles) {
+		return true
+	}
+	for _, needed := range neededRoles {
 		for _, role := range roles {
-			if r == role {
+			if needed == role {
 				return true
 			}
 		}
 	}
-	responses.NoAuthResponse(w, http.StatusUnauthorized, "You don't have permission to access this resource")
+	responses.Error(w, r, responses.CodeForbidden, "You don't have permission to access this resource")
 	return false
 }
 
 func WaValidateRoles(neededRoles []string, roles []string) bool {
+	if tenant.IsPlatformAdmin(roles) {
+		return true
+	}
 	for _, r := range neededRoles {
 		for _, role := range roles {
 			if r == role {
@@ -1,5 +1,21 @@
 package middleware
 
+import (
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/validation"
+)
+
 const (
+	// Admin of the organization the user signed in to
 	Admin = "admin"
+	// Admin of every organization, granted through the user's global roles
+	PlatformAdmin = tenant.PlatformAdminRole
 )
+
+// Roles requests may grant, validated with enum=roles. Platform admin is
+// granted outside the API so organization admins can't escalate to it.
+var AssignableRoles = []string{Admin}
+
+func init() {
+	validation.RegisterEnum("roles", AssignableRoles...)
+}
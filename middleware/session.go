@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/danielgz405/template-api-rest-go/server"
+)
+
+// Browser clients, the API ones and the admin console alike, may keep their
+// login token in an HttpOnly cookie instead of script storage. Requests
+// authenticated by that cookie are protected with a double submitted token:
+// the csrf_token cookie, readable by scripts of the page but not by other
+// sites, must be repeated in the X-CSRF-Token header, or the csrf field of
+// console forms, of every request that changes something.
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var errCSRF = errors.New("missing or invalid csrf token")
+
+// SetSession sets the session cookie and a new csrf cookie, it returns the
+// csrf token
+func SetSession(s server.Server, w http.ResponseWriter, token string) string {
+	maxAge := int(s.Config().TokenTTL.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.Config().SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.Config().SessionCookieSecure,
+		SameSite: s.Config().SessionSameSite(),
+	})
+	return setCSRF(s, w, maxAge)
+}
+
+func ClearSession(s server.Server, w http.ResponseWriter) {
+	for _, name := range []string{s.Config().SessionCookieName, CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name != CSRFCookie,
+			Secure:   s.Config().SessionCookieSecure,
+			SameSite: s.Config().SessionSameSite(),
+		})
+	}
+}
+
+// CSRFToken returns the csrf token of the request, setting its cookie when
+// there's none yet, for forms posted before signing in
+func CSRFToken(s server.Server, w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(CSRFCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return setCSRF(s, w, 0)
+}
+
+func setCSRF(s server.Server, w http.ResponseWriter, maxAge int) string {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	csrf := base64.RawURLEncoding.EncodeToString(random)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrf,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   s.Config().SessionCookieSecure,
+		SameSite: s.Config().SessionSameSite(),
+	})
+	return csrf
+}
+
+// SessionToken returns the login token of the session cookie, if any
+func SessionToken(s server.Server, r *http.Request) string {
+	cookie, err := r.Cookie(s.Config().SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// ValidCSRF tells if submitted repeats the csrf cookie of the request
+func ValidCSRF(r *http.Request, submitted string) bool {
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(submitted)) == 1
+}
+
+// validCSRF tells if the request repeats the csrf cookie in its header,
+// requests that can't change anything don't need to
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return ValidCSRF(r, r.Header.Get(CSRFHeader))
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AuditUserCreate  = "user.create"
+	AuditUserUpdate  = "user.update"
+	AuditUserDelete  = "user.delete"
+	AuditLogin       = "auth.login"
+	AuditLoginFailed = "auth.login_failed"
+
+	AuditOrganizationCreate = "organization.create"
+
+	AuditInvitationCreate = "invitation.create"
+	AuditInvitationResend = "invitation.resend"
+	AuditInvitationRevoke = "invitation.revoke"
+	AuditInvitationAccept = "invitation.accept"
+
+	AuditGroupCreate       = "group.create"
+	AuditGroupUpdate       = "group.update"
+	AuditGroupDelete       = "group.delete"
+	AuditGroupMemberAdd    = "group.member_add"
+	AuditGroupMemberRemove = "group.member_remove"
+)
+
+type AuditEntry struct {
+	Id             primitive.ObjectID     `bson:"_id,omitempty" json:"_id"`
+	OrganizationId string                 `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	ActorId        string                 `bson:"actorId" json:"actorId"`
+	ActorName      string                 `bson:"actorName" json:"actorName"`
+	Action         string                 `bson:"action" json:"action"`
+	TargetId       string                 `bson:"targetId" json:"targetId"`
+	Diff           map[string]AuditChange `bson:"diff,omitempty" json:"diff,omitempty"`
+	IP             string                 `bson:"ip" json:"ip"`
+	UserAgent      string                 `bson:"userAgent" json:"userAgent"`
+	RequestId      string                 `bson:"requestId" json:"requestId"`
+	CreatedAt      time.Time              `bson:"createdAt" json:"createdAt"`
+}
+
+type AuditChange struct {
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+type AuditFilter struct {
+	ActorId  string
+	TargetId string
+	Action   string
+	From     time.Time
+	To       time.Time
+	Limit    int64
+}
@@ -0,0 +1,16 @@
+package models
+
+type ChangeEvent struct {
+	Collection string      `json:"collection" bson:"collection"`
+	Operation  string      `json:"operation" bson:"operation"`
+	DocumentId string      `json:"documentId" bson:"documentId"`
+	Document   interface{} `json:"document" bson:"document"`
+	// Organizations that can see the document, unknown for deletes
+	Organizations []string `json:"organizations" bson:"organizations"`
+	ResumeToken   []byte   `json:"-" bson:"-"`
+}
+
+type ResumeToken struct {
+	Stream string `bson:"_id" json:"_id"`
+	Token  []byte `bson:"token" json:"token"`
+}
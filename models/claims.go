@@ -7,5 +7,13 @@ import (
 
 type AppClaims struct {
 	UserId primitive.ObjectID `bson:"userId"`
+	// Organization the user signed in to, zero for platform admins acting on every organization
+	OrganizationId primitive.ObjectID `bson:"organizationId"`
+	jwt.StandardClaims
+}
+
+// InvitationClaims are signed into the link sent to an invited email
+type InvitationClaims struct {
+	InvitationId primitive.ObjectID `bson:"invitationId"`
 	jwt.StandardClaims
 }
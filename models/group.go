@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Group grants its roles to every member, groups without an organization apply everywhere
+type Group struct {
+	Id             primitive.ObjectID   `bson:"_id" json:"_id"`
+	OrganizationId string               `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Name           string               `bson:"name" json:"name"`
+	Roles          []string             `bson:"roles" json:"roles"`
+	Members        []primitive.ObjectID `bson:"members" json:"members"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+type InsertGroup struct {
+	OrganizationId string               `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Name           string               `bson:"name" json:"name"`
+	Roles          []string             `bson:"roles" json:"roles"`
+	Members        []primitive.ObjectID `bson:"members" json:"members"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+type UpdateGroup struct {
+	Id    string   `bson:"_id" json:"_id"`
+	Name  string   `bson:"name" json:"name"`
+	Roles []string `bson:"roles" json:"roles"`
+}
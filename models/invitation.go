@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Invitation struct {
+	Id             primitive.ObjectID `bson:"_id" json:"_id"`
+	OrganizationId string             `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Email          string             `bson:"email" json:"email"`
+	Roles          []string           `bson:"roles" json:"roles"`
+	InvitedBy      string             `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      time.Time          `bson:"expiresAt" json:"expiresAt"`
+	AcceptedAt     *time.Time         `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+	RevokedAt      *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+type InsertInvitation struct {
+	OrganizationId string    `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Email          string    `bson:"email" json:"email"`
+	Roles          []string  `bson:"roles" json:"roles"`
+	InvitedBy      string    `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt      time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt      time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// Pending invitations can still be accepted
+func (invitation *Invitation) Pending(now time.Time) bool {
+	return invitation.AcceptedAt == nil && invitation.RevokedAt == nil && now.Before(invitation.ExpiresAt)
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Organization struct {
+	Id        primitive.ObjectID `bson:"_id" json:"_id"`
+	Name      string             `bson:"name" json:"name"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type InsertOrganization struct {
+	Name      string    `bson:"name" json:"name"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Membership grants a user roles inside one organization
+type Membership struct {
+	OrganizationId primitive.ObjectID `bson:"organizationId" json:"organizationId"`
+	Roles          []string           `bson:"roles" json:"roles"`
+}
@@ -3,30 +3,49 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	Id       primitive.ObjectID `bson:"_id" json:"_id"`
-	Name     string             `bson:"name" json:"name"`
-	Email    string             `bson:"email" json:"email"`
-	Password string             `bson:"password" json:"password"`
-	Roles    []string           `bson:"roles" json:"roles"`
+	Id          primitive.ObjectID `bson:"_id" json:"_id"`
+	Name        string             `bson:"name" json:"name"`
+	Email       string             `bson:"email" json:"email"`
+	Password    string             `bson:"password" json:"password"`
+	Roles       []string           `bson:"roles" json:"roles"`
+	Memberships []Membership       `bson:"memberships" json:"memberships"`
+	// Preferred language, empty follows Accept-Language
+	Locale string `bson:"locale,omitempty" json:"locale,omitempty"`
 }
 
+// Profile roles are the effective roles in the organization the profile was
+// read from, including the ones granted by groups. Memberships are only
+// listed when read without an organization.
 type Profile struct {
-	Id    primitive.ObjectID `bson:"_id" json:"_id"`
-	Name  string             `bson:"name" json:"name"`
-	Email string             `bson:"email" json:"email"`
-	Roles []string           `bson:"roles" json:"roles"`
+	Id          primitive.ObjectID `bson:"_id" json:"_id"`
+	Name        string             `bson:"name" json:"name"`
+	Email       string             `bson:"email" json:"email"`
+	Roles       []string           `bson:"roles" json:"roles"`
+	Groups      []string           `bson:"groups,omitempty" json:"groups,omitempty"`
+	Memberships []Membership       `bson:"memberships,omitempty" json:"memberships,omitempty"`
+	Locale      string             `bson:"locale,omitempty" json:"locale,omitempty"`
 }
 
 type InsertUser struct {
-	Name     string   `bson:"name" json:"name"`
-	Email    string   `bson:"email" json:"email"`
-	Password string   `bson:"password" json:"password"`
-	Roles    []string `bson:"roles" json:"roles"`
+	Name        string       `bson:"name" json:"name"`
+	Email       string       `bson:"email" json:"email"`
+	Password    string       `bson:"password" json:"password"`
+	Roles       []string     `bson:"roles" json:"roles"`
+	Memberships []Membership `bson:"memberships" json:"memberships"`
+	Locale      string       `bson:"locale,omitempty" json:"locale,omitempty"`
 }
 
 type UpdateUser struct {
-	Id    string   `bson:"_id" json:"_id"`
-	Name  string   `bson:"name" json:"name"`
-	Email string   `bson:"email" json:"email"`
-	Roles []string `bson:"roles" json:"roles"`
+	Id     string   `bson:"_id" json:"_id"`
+	Name   string   `bson:"name" json:"name"`
+	Email  string   `bson:"email" json:"email"`
+	Roles  []string `bson:"roles" json:"roles"`
+	Locale string   `bson:"locale" json:"locale"`
+}
+
+// UserFilter pages through users matching Query in their name or email
+type UserFilter struct {
+	Query string
+	Skip  int64
+	Limit int64
 }
@@ -4,4 +4,6 @@ type WebsocketMessage struct {
 	Code    string      `json:"code" bson:"code"`
 	Payload interface{} `json:"payload" bson:"payload"`
 	User    string      `json:"user" bson:"user"`
+	// W3C trace context of the change that produced the message
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
 }
@@ -0,0 +1,331 @@
+// Package openapi builds the OpenAPI 3.1 document of the API from the routes
+// as they are registered. Each route carries an Operation describing its
+// request and response types, the schemas are read from those types with
+// reflection, so the document follows the structures, responses and models
+// packages without being written by hand.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"github.com/gorilla/mux"
+)
+
+// Operation describes a route for the document
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Operations of a deprecated version
+	Deprecated bool
+	// Public operations don't need a login token
+	Public bool
+	// Roles of which the caller needs one, platform admins always pass
+	Roles []string
+	Query []Param
+	// A value of the JSON request type, nil when there's no JSON body
+	Body interface{}
+	// Media types of a raw request body, e.g. text/csv
+	BodyTypes []string
+	// Status of success, 200 when zero
+	Status int
+	// A value of the JSON response type, nil for an empty body
+	Response interface{}
+	// Media types of a raw response body, e.g. text/html
+	ResponseTypes []string
+	// Statuses besides success answering with a JSON body, e.g. 503 from /readyz
+	OtherResponses map[int]interface{}
+	// Errors besides the ones every operation of its kind may answer
+	Errors []responses.ErrorCode
+}
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Description string
+	// string when empty, integer, boolean or date-time
+	Type string
+	Enum []string
+}
+
+type route struct {
+	method    string
+	path      string
+	operation Operation
+}
+
+// Spec collects the documented routes
+type Spec struct {
+	title   string
+	version string
+	// name of the cookie of browser sessions
+	sessionCookie string
+	routes        []route
+
+	once     sync.Once
+	document []byte
+	err      error
+}
+
+func New(title string, version string, sessionCookie string) *Spec {
+	return &Spec{title: title, version: version, sessionCookie: sessionCookie}
+}
+
+// HandleFunc registers h like router.HandleFunc(path, h).Methods(method)
+// and documents it under its full path template, prefixes of subrouters
+// included.
+func (spec *Spec) HandleFunc(router *mux.Router, method string, path string, h http.HandlerFunc, operation Operation) *mux.Route {
+	r := router.HandleFunc(path, h).Methods(method)
+	template, err := r.GetPathTemplate()
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	spec.routes = append(spec.routes, route{method: method, path: template, operation: operation})
+	return r
+}
+
+// Handler serves the document, built on the first request once every
+// route is registered
+func (spec *Spec) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec.once.Do(func() {
+			spec.document, spec.err = json.Marshal(spec.Document())
+		})
+		if spec.err != nil {
+			responses.Error(w, r, responses.CodeInternal, "")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec.document)
+	}
+}
+
+// mux variables may carry a pattern, {id:[0-9]+}, which OpenAPI doesn't
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Document returns the OpenAPI document as JSON values
+func (spec *Spec) Document() map[string]interface{} {
+	schemas := newSchemas()
+	problem := schemas.of(responses.Problem{})
+	codes := []string{}
+	for _, code := range responses.Codes {
+		codes = append(codes, code.Code)
+	}
+
+	paths := map[string]interface{}{}
+	for _, route := range spec.routes {
+		path := pathParam.ReplaceAllString(route.path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.method)] = spec.operation(schemas, problem, route.method, path, route.operation)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   spec.title,
+			"version": spec.version,
+			"description": "Errors are application/problem+json documents, their code is one of: " +
+				strings.Join(codes, ", ") + ".",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "Login token returned by POST /login, sent as is",
+				},
+				"session": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        spec.sessionCookie,
+					"description": "Cookie set by POST /login with cookie: true, requests that change something must repeat the csrf_token cookie in the X-CSRF-Token header",
+				},
+			},
+		},
+	}
+}
+
+func (spec *Spec) operation(schemas *schemas, problem map[string]interface{}, method string, path string, operation Operation) map[string]interface{} {
+	result := map[string]interface{}{
+		"operationId": operationId(method, path),
+		"summary":     operation.Summary,
+	}
+	if len(operation.Tags) > 0 {
+		result["tags"] = operation.Tags
+	}
+	if operation.Deprecated {
+		result["deprecated"] = true
+	}
+	description := operation.Description
+	if len(operation.Roles) > 0 {
+		description = strings.TrimSpace(description + "\n\nRequires one of the roles: " + strings.Join(operation.Roles, ", ") + ".")
+	}
+	if description != "" {
+		result["description"] = description
+	}
+
+	parameters := []interface{}{}
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range operation.Query {
+		schema := map[string]interface{}{"type": "string"}
+		switch param.Type {
+		case "integer", "boolean":
+			schema["type"] = param.Type
+		case "date-time":
+			schema["format"] = param.Type
+		}
+		if len(param.Enum) > 0 {
+			schema["enum"] = param.Enum
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":        param.Name,
+			"in":          "query",
+			"description": param.Description,
+			"schema":      schema,
+		})
+	}
+	if len(parameters) > 0 {
+		result["parameters"] = parameters
+	}
+
+	if operation.Body != nil || len(operation.BodyTypes) > 0 {
+		content := map[string]interface{}{}
+		if operation.Body != nil {
+			content["application/json"] = map[string]interface{}{"schema": schemas.of(operation.Body)}
+		}
+		for _, mediaType := range operation.BodyTypes {
+			content[mediaType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		result["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+
+	if operation.Public {
+		result["security"] = []interface{}{}
+	} else {
+		result["security"] = []interface{}{
+			map[string]interface{}{"token": []string{}},
+			map[string]interface{}{"session": []string{}},
+		}
+	}
+
+	status := operation.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	results := map[string]interface{}{
+		fmt.Sprint(status): response(schemas, status, operation.Response, operation.ResponseTypes),
+	}
+	for status, body := range operation.OtherResponses {
+		results[fmt.Sprint(status)] = response(schemas, status, body, nil)
+	}
+	for status, codes := range errorsOf(operation, pathParam.MatchString(path)) {
+		titles := []string{}
+		names := []string{}
+		for _, code := range codes {
+			titles = append(titles, code.Title)
+			names = append(names, code.Code)
+		}
+		results[fmt.Sprint(status)] = map[string]interface{}{
+			"description": strings.Join(titles, "; "),
+			"content": map[string]interface{}{
+				responses.ProblemContentType: map[string]interface{}{
+					"schema": map[string]interface{}{
+						"allOf": []interface{}{
+							problem,
+							map[string]interface{}{
+								"properties": map[string]interface{}{
+									"code": map[string]interface{}{"enum": names},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	result["responses"] = results
+	return result
+}
+
+func response(schemas *schemas, status int, body interface{}, mediaTypes []string) map[string]interface{} {
+	result := map[string]interface{}{"description": http.StatusText(status)}
+	content := map[string]interface{}{}
+	if body != nil {
+		content["application/json"] = map[string]interface{}{"schema": schemas.of(body)}
+	}
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	}
+	if len(content) > 0 {
+		result["content"] = content
+	}
+	return result
+}
+
+// errorsOf groups by status the errors the operation may answer, the ones
+// of its own and the ones its kind implies
+func errorsOf(operation Operation, hasPathParams bool) map[int][]responses.ErrorCode {
+	codes := append([]responses.ErrorCode{}, operation.Errors...)
+	if !operation.Public {
+		codes = append(codes, responses.CodeUnauthorized, responses.CodeCSRFFailed)
+	}
+	if len(operation.Roles) > 0 {
+		codes = append(codes, responses.CodeForbidden)
+	}
+	if operation.Body != nil {
+		codes = append(codes, responses.CodeInvalidBody, responses.CodeBodyTooLarge, responses.CodeValidationFailed)
+	}
+	if hasPathParams {
+		codes = append(codes, responses.CodeNotFound)
+	}
+	codes = append(codes, responses.CodeInternal)
+
+	byStatus := map[int][]responses.ErrorCode{}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code.Code] {
+			continue
+		}
+		seen[code.Code] = true
+		byStatus[code.Status] = append(byStatus[code.Status], code)
+	}
+	for _, codes := range byStatus {
+		sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	}
+	return byStatus
+}
+
+// operationId turns POST /user/update/{id} into postUserUpdateById
+func operationId(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			segment = "by_" + strings.TrimSuffix(name, "}")
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
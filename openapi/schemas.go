@@ -0,0 +1,173 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIdType = reflect.TypeOf(primitive.ObjectID{})
+)
+
+// schemas reads JSON schemas from Go types, named structs become components
+// referenced by name
+type schemas struct {
+	components map[string]interface{}
+	types      map[string]reflect.Type
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]interface{}{}, types: map[string]reflect.Type{}}
+}
+
+// of returns the schema of the type of v
+func (s *schemas) of(v interface{}) map[string]interface{} {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIdType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if known, ok := s.types[t.Name()]; ok {
+			if known != t {
+				panic("openapi: two types are named " + t.Name())
+			}
+			return ref
+		}
+		s.types[t.Name()] = t
+		s.components[t.Name()] = s.object(t)
+		return ref
+	}
+	// interface{} holds anything
+	return map[string]interface{}{}
+}
+
+// object reads the properties of a struct like encoding/json does. Request
+// structures list their required fields in validate tags, the rest are
+// responses whose fields are always present unless omitempty.
+func (s *schemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	validated := false
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("validate"); ok {
+			validated = true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := s.object(field.Type)
+			for key, value := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = value
+			}
+			if names, ok := embedded["required"].([]string); ok {
+				required = append(required, names...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := s.schema(field.Type)
+		rules := field.Tag.Get("validate")
+		if rules != "" {
+			schema = constrain(schema, strings.Split(rules, ","))
+		}
+		properties[name] = schema
+		if validated && strings.Contains(","+rules+",", ",required,") ||
+			!validated && !strings.Contains(","+options+",", ",omitempty,") {
+			required = append(required, name)
+		}
+	}
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// constrain adds the validate rules to a schema, the ones after dive go to its items
+func constrain(schema map[string]interface{}, rules []string) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return schema
+	}
+	constrained := map[string]interface{}{}
+	for key, value := range schema {
+		constrained[key] = value
+	}
+	for i, rule := range rules {
+		if rule == "dive" {
+			if items, ok := constrained["items"].(map[string]interface{}); ok {
+				constrained["items"] = constrain(items, rules[i+1:])
+			}
+			break
+		}
+		rule, argument, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "email":
+			constrained["format"] = "email"
+		case "min", "max":
+			limit, _ := strconv.Atoi(argument)
+			keyword := map[string]string{"min": "minLength", "max": "maxLength"}[rule]
+			if constrained["type"] == "array" {
+				keyword = map[string]string{"min": "minItems", "max": "maxItems"}[rule]
+			}
+			constrained[keyword] = limit
+		case "maxbytes":
+			// JSON Schema counts characters, a byte limit is at least as strict
+			limit, _ := strconv.Atoi(argument)
+			if _, ok := constrained["maxLength"]; !ok {
+				constrained["maxLength"] = limit
+			}
+			constrained["description"] = fmt.Sprintf("At most %d bytes in UTF-8.", limit)
+		case "oneof":
+			constrained["enum"] = strings.Fields(argument)
+		case "enum":
+			if values, ok := validation.Enum(argument); ok {
+				constrained["enum"] = values
+			}
+		}
+	}
+	return constrained
+}
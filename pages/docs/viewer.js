@@ -0,0 +1,181 @@
+// API reference of /docs when no Redoc bundle is configured. It renders the
+// OpenAPI document named by data-spec-url of #api-reference, grouping the
+// operations by tag. Text from the document is only set with textContent.
+(function () {
+  "use strict";
+
+  var root = document.getElementById("api-reference");
+  var labels = root.dataset;
+  var methods = ["get", "post", "put", "patch", "delete"];
+
+  function element(tag, className, text) {
+    var node = document.createElement(tag);
+    if (className) {
+      node.className = className;
+    }
+    if (text !== undefined && text !== null) {
+      node.textContent = String(text);
+    }
+    return node;
+  }
+
+  function row(cells) {
+    var tr = element("tr");
+    cells.forEach(function (cell, i) {
+      tr.appendChild(element("td", ["name", "type", ""][i], cell));
+    });
+    return tr;
+  }
+
+  // Follows a local reference such as #/components/schemas/Profile
+  function resolve(spec, value) {
+    if (!value || typeof value.$ref !== "string" || value.$ref.indexOf("#/") !== 0) {
+      return value;
+    }
+    return value.$ref
+      .slice(2)
+      .split("/")
+      .reduce(function (node, key) {
+        return node ? node[key] : undefined;
+      }, spec);
+  }
+
+  function schemaName(schema) {
+    if (!schema) {
+      return "";
+    }
+    if (schema.$ref) {
+      return schema.$ref.split("/").pop();
+    }
+    if (schema.type === "array") {
+      return schemaName(schema.items) + "[]";
+    }
+    return (schema.type || "object") + (schema.format ? " (" + schema.format + ")" : "");
+  }
+
+  function properties(spec, schema) {
+    schema = resolve(spec, schema);
+    if (!schema || !schema.properties) {
+      return null;
+    }
+    var required = schema.required || [];
+    var table = element("table");
+    Object.keys(schema.properties).forEach(function (name) {
+      var property = schema.properties[name];
+      var mark = required.indexOf(name) >= 0 ? " *" : "";
+      table.appendChild(row([name + mark, schemaName(property), property.description || ""]));
+    });
+    return table;
+  }
+
+  function operation(spec, method, path, op) {
+    var section = element("section", "operation");
+    var title = element("h3");
+    title.appendChild(element("span", "method " + method, method.toUpperCase()));
+    title.appendChild(element("code", "", path));
+    section.appendChild(title);
+    if (op.summary) {
+      section.appendChild(element("p", "summary", op.summary));
+    }
+    if (op.description) {
+      section.appendChild(element("p", "", op.description));
+    }
+
+    var parameters = op.parameters || [];
+    if (parameters.length > 0) {
+      section.appendChild(element("h4", "", labels.parameters));
+      var parameterTable = element("table");
+      parameters.forEach(function (parameter) {
+        parameter = resolve(spec, parameter);
+        var mark = parameter.required ? " *" : "";
+        parameterTable.appendChild(
+          row([parameter.name + mark, parameter.in + ", " + schemaName(parameter.schema), parameter.description || ""])
+        );
+      });
+      section.appendChild(parameterTable);
+    }
+
+    var body = resolve(spec, op.requestBody);
+    if (body && body.content) {
+      section.appendChild(element("h4", "", labels.requestBody));
+      Object.keys(body.content).forEach(function (type) {
+        var schema = body.content[type].schema;
+        section.appendChild(element("p", "type", type + ": " + schemaName(schema)));
+        var table = properties(spec, schema);
+        if (table) {
+          section.appendChild(table);
+        }
+      });
+    }
+
+    var responses = op.responses || {};
+    if (Object.keys(responses).length > 0) {
+      section.appendChild(element("h4", "", labels.responses));
+      var responseTable = element("table");
+      Object.keys(responses).forEach(function (status) {
+        var response = resolve(spec, responses[status]) || {};
+        var content = response.content || {};
+        var types = Object.keys(content).map(function (type) {
+          return type + ": " + schemaName(content[type].schema);
+        });
+        responseTable.appendChild(row([status, types.join(", "), response.description || ""]));
+      });
+      section.appendChild(responseTable);
+    }
+    return section;
+  }
+
+  function render(spec) {
+    root.textContent = "";
+    var info = spec.info || {};
+    root.appendChild(element("h1", "", (info.title || "") + " " + (info.version || "")));
+    if (info.description) {
+      root.appendChild(element("p", "", info.description));
+    }
+
+    var tags = [];
+    var operations = {};
+    Object.keys(spec.paths || {}).forEach(function (path) {
+      var item = spec.paths[path];
+      methods.forEach(function (method) {
+        var op = item[method];
+        if (!op) {
+          return;
+        }
+        var tag = (op.tags && op.tags[0]) || "";
+        if (!operations[tag]) {
+          operations[tag] = [];
+          tags.push(tag);
+        }
+        operations[tag].push(operation(spec, method, path, op));
+      });
+    });
+    tags.forEach(function (tag) {
+      if (tag) {
+        root.appendChild(element("h2", "", tag));
+      }
+      operations[tag].forEach(function (section) {
+        root.appendChild(section);
+      });
+    });
+  }
+
+  function failed() {
+    root.textContent = "";
+    var message = element("p", "", labels.failed + " ");
+    var link = element("a", "", labels.specUrl);
+    link.href = labels.specUrl;
+    message.appendChild(link);
+    root.appendChild(message);
+  }
+
+  fetch(labels.specUrl, { headers: { Accept: "application/json" } })
+    .then(function (response) {
+      if (!response.ok) {
+        throw new Error("status " + response.status);
+      }
+      return response.json();
+    })
+    .then(render)
+    .catch(failed);
+})();
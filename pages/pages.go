@@ -1,20 +1,136 @@
+// Package pages renders the HTML pages. Every page is a directory holding
+// <page>.html, which defines "page", usually by calling a layout, and the
+// blocks that layout leaves open; layouts and partials are shared by all
+// pages. Values are escaped by html/template.
 package pages
 
 import (
+	"bytes"
+	"embed"
 	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
 	"os"
-	"strings"
+	"path"
+	"slices"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
 )
 
-func Welcome(name string) (string, error) {
-	filePath := "./pages/welcome/welcome.html"
+//go:embed layouts partials */*.html
+var files embed.FS
+
+// DocsViewer renders the API reference of the docs page without a Redoc bundle
+//
+//go:embed docs/viewer.js
+var DocsViewer []byte
+
+var funcs = template.FuncMap{
+	// {{t .Locale "English message" args...}}
+	"t": i18n.T,
+	// {{if has .Roles "admin"}}
+	"has": func(values []string, value string) bool {
+		return slices.Contains(values, value)
+	},
+	"add": func(a int, b int) int {
+		return a + b
+	},
+}
 
-	content, err := os.ReadFile(filePath)
+type Renderer struct {
+	// Set in dev mode, templates are parsed from it on every render
+	dir       string
+	templates map[string]*template.Template
+}
+
+// New parses the embedded templates once. With dir set they are read from
+// that directory instead and parsed again on every render, so edits show up
+// without a restart.
+func New(dir string) (*Renderer, error) {
+	renderer := &Renderer{dir: dir}
+	if dir != "" {
+		// Fail on startup rather than on the first request
+		_, err := parse(os.DirFS(dir))
+		return renderer, err
+	}
+	templates, err := parse(files)
 	if err != nil {
-		return "", fmt.Errorf("no se pudo leer el archivo HTML: %w", err)
+		return nil, err
 	}
+	renderer.templates = templates
+	return renderer, nil
+}
 
-	html := strings.Replace(string(content), "${{name}}", name, -1)
+// parse builds every page of fsys along with the layouts and partials
+func parse(fsys fs.FS) (map[string]*template.Template, error) {
+	shared, err := template.New("").Funcs(funcs).ParseFS(fsys, "layouts/*.html", "partials/*.html")
+	if err != nil {
+		return nil, err
+	}
+	pages, err := fs.Glob(fsys, "*/*.html")
+	if err != nil {
+		return nil, err
+	}
+	templates := map[string]*template.Template{}
+	for _, file := range pages {
+		dir := path.Dir(file)
+		if dir == "layouts" || dir == "partials" {
+			continue
+		}
+		page, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if page, err = page.ParseFS(fsys, file); err != nil {
+			return nil, err
+		}
+		templates[dir] = page
+	}
+	return templates, nil
+}
+
+// Render writes the page with data, nothing is written when it fails
+func (renderer *Renderer) Render(w io.Writer, page string, data interface{}) error {
+	templates := renderer.templates
+	if renderer.dir != "" {
+		var err error
+		if templates, err = parse(os.DirFS(renderer.dir)); err != nil {
+			return err
+		}
+	}
+	t, ok := templates[page]
+	if !ok {
+		return fmt.Errorf("unknown page %s", page)
+	}
+	var buffer bytes.Buffer
+	if err := t.ExecuteTemplate(&buffer, "page", data); err != nil {
+		return err
+	}
+	_, err := buffer.WriteTo(w)
+	return err
+}
+
+type WelcomeData struct {
+	Locale string
+	Name   string
+}
+
+func (renderer *Renderer) Welcome(w io.Writer, locale string, name string) error {
+	return renderer.Render(w, "welcome", WelcomeData{Locale: locale, Name: name})
+}
+
+type DocsData struct {
+	Locale  string
+	SpecURL string
+	// Redoc bundle and its integrity hash, without a bundle the page loads
+	// DocsViewer from ViewerURL
+	ScriptURL       string
+	ScriptIntegrity string
+	ViewerURL       string
+}
 
-	return html, nil
+// Docs is the API reference of the OpenAPI document at SpecURL
+func (renderer *Renderer) Docs(w io.Writer, data DocsData) error {
+	return renderer.Render(w, "docs", data)
 }
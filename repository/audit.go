@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+func InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	return implementation.InsertAuditEntry(ctx, entry)
+}
+
+func ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	return implementation.ListAuditEntries(ctx, filter)
+}
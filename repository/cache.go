@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheOptions struct {
+	// How long a profile is served from memory
+	TTL time.Duration
+	// Maximum number of cached profiles, the least recently used is evicted first
+	MaxSize int
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// CachedRepository is a read-through cache of user profiles in front of
+// another Repository. Every method it doesn't override goes straight to the
+// wrapped implementation.
+type CachedRepository struct {
+	Repository
+	cache *profileCache
+	// set on the repo handed to a WithTransaction callback
+	tx *cacheTx
+}
+
+func NewCachedRepository(next Repository, options CacheOptions) *CachedRepository {
+	return &CachedRepository{
+		Repository: next,
+		cache: &profileCache{
+			ttl:     options.TTL,
+			maxSize: options.MaxSize,
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+		},
+	}
+}
+
+func (repo *CachedRepository) Stats() CacheStats {
+	return repo.cache.stats()
+}
+
+func (repo *CachedRepository) GetUserById(ctx context.Context, id string) (*models.Profile, error) {
+	// Reads inside a transaction may see uncommitted data, keep them out of the cache
+	if repo.tx != nil {
+		return repo.Repository.GetUserById(ctx, id)
+	}
+	// Profiles differ per organization, each scope gets its own entry
+	key := tenant.Key(ctx) + "|" + id
+	if profile, ok := repo.cache.get(key); ok {
+		return profile, nil
+	}
+	// Concurrent misses for the same user share a single query, which must
+	// not fail for everyone when the request that started it goes away. The
+	// generation is taken by the query itself, a caller joining it late
+	// mustn't store a result loaded before a write it already saw.
+	result, err, _ := repo.cache.group.Do(key, func() (interface{}, error) {
+		generation := repo.cache.currentGeneration()
+		profile, err := repo.Repository.GetUserById(context.WithoutCancel(ctx), id)
+		if err != nil {
+			return nil, err
+		}
+		return loadedProfile{profile: profile, generation: generation}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	loaded := result.(loadedProfile)
+	repo.cache.set(key, id, loaded.profile, loaded.generation)
+	return copyProfile(loaded.profile), nil
+}
+
+func (repo *CachedRepository) UpdateUser(ctx context.Context, data models.UpdateUser) (*models.Profile, error) {
+	defer repo.invalidate(data.Id)
+	return repo.Repository.UpdateUser(ctx, data)
+}
+
+func (repo *CachedRepository) DeleteUser(ctx context.Context, id string) error {
+	defer repo.invalidate(id)
+	return repo.Repository.DeleteUser(ctx, id)
+}
+
+func (repo *CachedRepository) UpdateUserPassword(ctx context.Context, userId string, newPassword string) (*models.Profile, error) {
+	defer repo.invalidate(userId)
+	return repo.Repository.UpdateUserPassword(ctx, userId, newPassword)
+}
+
+func (repo *CachedRepository) JoinOrganization(ctx context.Context, userId string, roles []string) (*models.Profile, error) {
+	defer repo.invalidate(userId)
+	return repo.Repository.JoinOrganization(ctx, userId, roles)
+}
+
+// Group changes alter the roles of every member, they are rare enough to drop the whole cache
+func (repo *CachedRepository) InsertGroup(ctx context.Context, group *models.InsertGroup) (*models.Group, error) {
+	defer repo.invalidateAll()
+	return repo.Repository.InsertGroup(ctx, group)
+}
+
+func (repo *CachedRepository) UpdateGroup(ctx context.Context, data models.UpdateGroup) (*models.Group, error) {
+	defer repo.invalidateAll()
+	return repo.Repository.UpdateGroup(ctx, data)
+}
+
+func (repo *CachedRepository) DeleteGroup(ctx context.Context, id string) error {
+	defer repo.invalidateAll()
+	return repo.Repository.DeleteGroup(ctx, id)
+}
+
+func (repo *CachedRepository) AddGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	defer repo.invalidate(userId)
+	return repo.Repository.AddGroupMember(ctx, groupId, userId)
+}
+
+func (repo *CachedRepository) RemoveGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	defer repo.invalidate(userId)
+	return repo.Repository.RemoveGroupMember(ctx, groupId, userId)
+}
+
+func (repo *CachedRepository) WithTransaction(ctx context.Context, fn func(tx Repository) error) error {
+	// Already inside a transaction, join it
+	if repo.tx != nil {
+		return fn(repo)
+	}
+	tx := &cacheTx{}
+	err := repo.Repository.WithTransaction(ctx, func(inner Repository) error {
+		return fn(&CachedRepository{Repository: inner, cache: repo.cache, tx: tx})
+	})
+	// Readers may have refilled the cache with the old values before commit
+	if tx.touchedAll() {
+		repo.cache.clear()
+	}
+	for _, id := range tx.touched() {
+		repo.cache.invalidate(id)
+	}
+	return err
+}
+
+func (repo *CachedRepository) invalidate(id string) {
+	repo.cache.invalidate(id)
+	if repo.tx != nil {
+		repo.tx.touch(id)
+	}
+}
+
+func (repo *CachedRepository) invalidateAll() {
+	repo.cache.clear()
+	if repo.tx != nil {
+		repo.tx.touchAll()
+	}
+}
+
+type cacheTx struct {
+	mutex sync.Mutex
+	ids   []string
+	all   bool
+}
+
+func (tx *cacheTx) touch(id string) {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	tx.ids = append(tx.ids, id)
+}
+
+func (tx *cacheTx) touchAll() {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	tx.all = true
+}
+
+func (tx *cacheTx) touchedAll() bool {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	return tx.all
+}
+
+func (tx *cacheTx) touched() []string {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	return tx.ids
+}
+
+type loadedProfile struct {
+	profile    *models.Profile
+	generation uint64
+}
+
+type cacheEntry struct {
+	key       string
+	userId    string
+	profile   *models.Profile
+	expiresAt time.Time
+}
+
+type profileCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	// bumped on every invalidation so a load that raced with a write isn't stored
+	generation uint64
+
+	group     singleflight.Group
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func (c *profileCache) get(key string) (*models.Profile, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	c.hits.Add(1)
+	return copyProfile(entry.profile), true
+}
+
+func (c *profileCache) set(key string, userId string, profile *models.Profile, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, userId: userId, profile: copyProfile(profile), expiresAt: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// invalidate drops the user from every organization scope
+func (c *profileCache) invalidate(userId string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for key, element := range c.entries {
+		if element.Value.(*cacheEntry).userId == userId {
+			c.order.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *profileCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *profileCache) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+func (c *profileCache) stats() CacheStats {
+	c.mutex.Lock()
+	size := c.order.Len()
+	c.mutex.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// Callers get their own copy so nobody can modify the cached profile
+func copyProfile(profile *models.Profile) *models.Profile {
+	clone := *profile
+	clone.Roles = append([]string(nil), profile.Roles...)
+	clone.Groups = append([]string(nil), profile.Groups...)
+	clone.Memberships = append([]models.Membership(nil), profile.Memberships...)
+	return &clone
+}
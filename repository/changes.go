@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+func WatchCollection(ctx context.Context, collection string, resumeToken []byte, handle func(event models.ChangeEvent) error) error {
+	return implementation.WatchCollection(ctx, collection, resumeToken, handle)
+}
+
+func GetResumeToken(ctx context.Context, stream string) ([]byte, error) {
+	return implementation.GetResumeToken(ctx, stream)
+}
+
+func SaveResumeToken(ctx context.Context, stream string, token []byte) error {
+	return implementation.SaveResumeToken(ctx, stream, token)
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+func InsertGroup(ctx context.Context, group *models.InsertGroup) (*models.Group, error) {
+	return implementation.InsertGroup(ctx, group)
+}
+
+func GetGroupById(ctx context.Context, id string) (*models.Group, error) {
+	return implementation.GetGroupById(ctx, id)
+}
+
+func ListGroups(ctx context.Context) ([]models.Group, error) {
+	return implementation.ListGroups(ctx)
+}
+
+func UpdateGroup(ctx context.Context, data models.UpdateGroup) (*models.Group, error) {
+	return implementation.UpdateGroup(ctx, data)
+}
+
+func DeleteGroup(ctx context.Context, id string) error {
+	return implementation.DeleteGroup(ctx, id)
+}
+
+func AddGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	return implementation.AddGroupMember(ctx, groupId, userId)
+}
+
+func RemoveGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	return implementation.RemoveGroupMember(ctx, groupId, userId)
+}
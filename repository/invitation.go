@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+func InsertInvitation(ctx context.Context, invitation *models.InsertInvitation) (*models.Invitation, error) {
+	return implementation.InsertInvitation(ctx, invitation)
+}
+
+func GetInvitationById(ctx context.Context, id string) (*models.Invitation, error) {
+	return implementation.GetInvitationById(ctx, id)
+}
+
+func ListInvitations(ctx context.Context, pendingOnly bool) ([]models.Invitation, error) {
+	return implementation.ListInvitations(ctx, pendingOnly)
+}
+
+func RenewInvitation(ctx context.Context, id string, expiresAt time.Time) (*models.Invitation, error) {
+	return implementation.RenewInvitation(ctx, id, expiresAt)
+}
+
+func RevokeInvitation(ctx context.Context, id string) error {
+	return implementation.RevokeInvitation(ctx, id)
+}
+
+func AcceptInvitation(ctx context.Context, id string) error {
+	return implementation.AcceptInvitation(ctx, id)
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+// Observer is told when a repository call starts, it may return a derived
+// context for the call, and the returned func is called with its result.
+type Observer func(ctx context.Context, method string) (context.Context, func(err error))
+
+// ObservedRepository reports every call of another Repository to an
+// observer, used for metrics and tracing.
+type ObservedRepository struct {
+	next    Repository
+	observe Observer
+}
+
+func NewObservedRepository(next Repository, observe Observer) *ObservedRepository {
+	return &ObservedRepository{next: next, observe: observe}
+}
+
+func (repo *ObservedRepository) InsertUser(ctx context.Context, user *models.InsertUser) (*models.Profile, error) {
+	ctx, done := repo.observe(ctx, "InsertUser")
+	result, err := repo.next.InsertUser(ctx, user)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) InsertUsers(ctx context.Context, users []*models.InsertUser) ([]models.Profile, error) {
+	ctx, done := repo.observe(ctx, "InsertUsers")
+	result, err := repo.next.InsertUsers(ctx, users)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) GetUserById(ctx context.Context, id string) (*models.Profile, error) {
+	ctx, done := repo.observe(ctx, "GetUserById")
+	result, err := repo.next.GetUserById(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, done := repo.observe(ctx, "GetUserByEmail")
+	result, err := repo.next.GetUserByEmail(ctx, email)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) UpdateUser(ctx context.Context, data models.UpdateUser) (*models.Profile, error) {
+	ctx, done := repo.observe(ctx, "UpdateUser")
+	result, err := repo.next.UpdateUser(ctx, data)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) DeleteUser(ctx context.Context, id string) error {
+	ctx, done := repo.observe(ctx, "DeleteUser")
+	err := repo.next.DeleteUser(ctx, id)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) UpdateUserPassword(ctx context.Context, userId string, newPassword string) (*models.Profile, error) {
+	ctx, done := repo.observe(ctx, "UpdateUserPassword")
+	result, err := repo.next.UpdateUserPassword(ctx, userId, newPassword)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) ListUsers(ctx context.Context) ([]models.Profile, error) {
+	ctx, done := repo.observe(ctx, "ListUsers")
+	result, err := repo.next.ListUsers(ctx)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) SearchUsers(ctx context.Context, filter models.UserFilter) ([]models.Profile, int64, error) {
+	ctx, done := repo.observe(ctx, "SearchUsers")
+	result, total, err := repo.next.SearchUsers(ctx, filter)
+	done(err)
+	return result, total, err
+}
+
+func (repo *ObservedRepository) EachUser(ctx context.Context, fn func(profile models.Profile) error) error {
+	ctx, done := repo.observe(ctx, "EachUser")
+	err := repo.next.EachUser(ctx, fn)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) JoinOrganization(ctx context.Context, userId string, roles []string) (*models.Profile, error) {
+	ctx, done := repo.observe(ctx, "JoinOrganization")
+	result, err := repo.next.JoinOrganization(ctx, userId, roles)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) InsertOrganization(ctx context.Context, organization *models.InsertOrganization) (*models.Organization, error) {
+	ctx, done := repo.observe(ctx, "InsertOrganization")
+	result, err := repo.next.InsertOrganization(ctx, organization)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) GetOrganizationById(ctx context.Context, id string) (*models.Organization, error) {
+	ctx, done := repo.observe(ctx, "GetOrganizationById")
+	result, err := repo.next.GetOrganizationById(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	ctx, done := repo.observe(ctx, "ListOrganizations")
+	result, err := repo.next.ListOrganizations(ctx)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) InsertGroup(ctx context.Context, group *models.InsertGroup) (*models.Group, error) {
+	ctx, done := repo.observe(ctx, "InsertGroup")
+	result, err := repo.next.InsertGroup(ctx, group)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) GetGroupById(ctx context.Context, id string) (*models.Group, error) {
+	ctx, done := repo.observe(ctx, "GetGroupById")
+	result, err := repo.next.GetGroupById(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) ListGroups(ctx context.Context) ([]models.Group, error) {
+	ctx, done := repo.observe(ctx, "ListGroups")
+	result, err := repo.next.ListGroups(ctx)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) UpdateGroup(ctx context.Context, data models.UpdateGroup) (*models.Group, error) {
+	ctx, done := repo.observe(ctx, "UpdateGroup")
+	result, err := repo.next.UpdateGroup(ctx, data)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) DeleteGroup(ctx context.Context, id string) error {
+	ctx, done := repo.observe(ctx, "DeleteGroup")
+	err := repo.next.DeleteGroup(ctx, id)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) AddGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	ctx, done := repo.observe(ctx, "AddGroupMember")
+	result, err := repo.next.AddGroupMember(ctx, groupId, userId)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) RemoveGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error) {
+	ctx, done := repo.observe(ctx, "RemoveGroupMember")
+	result, err := repo.next.RemoveGroupMember(ctx, groupId, userId)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) InsertInvitation(ctx context.Context, invitation *models.InsertInvitation) (*models.Invitation, error) {
+	ctx, done := repo.observe(ctx, "InsertInvitation")
+	result, err := repo.next.InsertInvitation(ctx, invitation)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) GetInvitationById(ctx context.Context, id string) (*models.Invitation, error) {
+	ctx, done := repo.observe(ctx, "GetInvitationById")
+	result, err := repo.next.GetInvitationById(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) ListInvitations(ctx context.Context, pendingOnly bool) ([]models.Invitation, error) {
+	ctx, done := repo.observe(ctx, "ListInvitations")
+	result, err := repo.next.ListInvitations(ctx, pendingOnly)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) RenewInvitation(ctx context.Context, id string, expiresAt time.Time) (*models.Invitation, error) {
+	ctx, done := repo.observe(ctx, "RenewInvitation")
+	result, err := repo.next.RenewInvitation(ctx, id, expiresAt)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) RevokeInvitation(ctx context.Context, id string) error {
+	ctx, done := repo.observe(ctx, "RevokeInvitation")
+	err := repo.next.RevokeInvitation(ctx, id)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) AcceptInvitation(ctx context.Context, id string) error {
+	ctx, done := repo.observe(ctx, "AcceptInvitation")
+	err := repo.next.AcceptInvitation(ctx, id)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	ctx, done := repo.observe(ctx, "InsertAuditEntry")
+	err := repo.next.InsertAuditEntry(ctx, entry)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, done := repo.observe(ctx, "ListAuditEntries")
+	result, err := repo.next.ListAuditEntries(ctx, filter)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) WatchCollection(ctx context.Context, collection string, resumeToken []byte, handle func(event models.ChangeEvent) error) error {
+	ctx, done := repo.observe(ctx, "WatchCollection")
+	err := repo.next.WatchCollection(ctx, collection, resumeToken, handle)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) GetResumeToken(ctx context.Context, stream string) ([]byte, error) {
+	ctx, done := repo.observe(ctx, "GetResumeToken")
+	result, err := repo.next.GetResumeToken(ctx, stream)
+	done(err)
+	return result, err
+}
+
+func (repo *ObservedRepository) SaveResumeToken(ctx context.Context, stream string, token []byte) error {
+	ctx, done := repo.observe(ctx, "SaveResumeToken")
+	err := repo.next.SaveResumeToken(ctx, stream, token)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) WithTransaction(ctx context.Context, fn func(tx Repository) error) error {
+	ctx, done := repo.observe(ctx, "WithTransaction")
+	err := repo.next.WithTransaction(ctx, func(tx Repository) error {
+		// Calls made inside the transaction are observed too
+		return fn(&ObservedRepository{next: tx, observe: repo.observe})
+	})
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) Ping(ctx context.Context) error {
+	ctx, done := repo.observe(ctx, "Ping")
+	err := repo.next.Ping(ctx)
+	done(err)
+	return err
+}
+
+func (repo *ObservedRepository) Close() error {
+	return repo.next.Close()
+}
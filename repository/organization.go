@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+)
+
+func InsertOrganization(ctx context.Context, organization *models.InsertOrganization) (*models.Organization, error) {
+	return implementation.InsertOrganization(ctx, organization)
+}
+
+func GetOrganizationById(ctx context.Context, id string) (*models.Organization, error) {
+	return implementation.GetOrganizationById(ctx, id)
+}
+
+func ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	return implementation.ListOrganizations(ctx)
+}
@@ -2,20 +2,84 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/danielgz405/template-api-rest-go/models"
 )
 
+// InsertUsersError is returned by InsertUsers when a user can't be
+// inserted. Users are inserted in order and the insert stops at the failing
+// one, the ones before it are inserted.
+type InsertUsersError struct {
+	Inserted []models.Profile
+	// Position of the failing user, len(Inserted)
+	Index int
+	// The failed write aborted the surrounding transaction, nothing it wrote
+	// is kept, Inserted included
+	Aborted bool
+	Err     error
+}
+
+func (e *InsertUsersError) Error() string {
+	return fmt.Sprintf("inserting user %d: %s", e.Index, e.Err)
+}
+
+func (e *InsertUsersError) Unwrap() error {
+	return e.Err
+}
+
 type Repository interface {
 
 	//Users
 	InsertUser(ctx context.Context, user *models.InsertUser) (*models.Profile, error)
+	InsertUsers(ctx context.Context, users []*models.InsertUser) ([]models.Profile, error)
 	GetUserById(ctx context.Context, id string) (*models.Profile, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	UpdateUser(ctx context.Context, data models.UpdateUser) (*models.Profile, error)
 	DeleteUser(ctx context.Context, id string) error
 	UpdateUserPassword(ctx context.Context, userId string, newPassword string) (profile *models.Profile, err error)
 	ListUsers(ctx context.Context) ([]models.Profile, error)
+	SearchUsers(ctx context.Context, filter models.UserFilter) ([]models.Profile, int64, error)
+	EachUser(ctx context.Context, fn func(profile models.Profile) error) error
+	JoinOrganization(ctx context.Context, userId string, roles []string) (*models.Profile, error)
+
+	//Organizations
+	InsertOrganization(ctx context.Context, organization *models.InsertOrganization) (*models.Organization, error)
+	GetOrganizationById(ctx context.Context, id string) (*models.Organization, error)
+	ListOrganizations(ctx context.Context) ([]models.Organization, error)
+
+	//Groups
+	InsertGroup(ctx context.Context, group *models.InsertGroup) (*models.Group, error)
+	GetGroupById(ctx context.Context, id string) (*models.Group, error)
+	ListGroups(ctx context.Context) ([]models.Group, error)
+	UpdateGroup(ctx context.Context, data models.UpdateGroup) (*models.Group, error)
+	DeleteGroup(ctx context.Context, id string) error
+	AddGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error)
+	RemoveGroupMember(ctx context.Context, groupId string, userId string) (*models.Group, error)
+
+	//Invitations
+	InsertInvitation(ctx context.Context, invitation *models.InsertInvitation) (*models.Invitation, error)
+	GetInvitationById(ctx context.Context, id string) (*models.Invitation, error)
+	ListInvitations(ctx context.Context, pendingOnly bool) ([]models.Invitation, error)
+	RenewInvitation(ctx context.Context, id string, expiresAt time.Time) (*models.Invitation, error)
+	RevokeInvitation(ctx context.Context, id string) error
+	AcceptInvitation(ctx context.Context, id string) error
+
+	//Audit
+	InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+	ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+
+	//Change streams
+	WatchCollection(ctx context.Context, collection string, resumeToken []byte, handle func(event models.ChangeEvent) error) error
+	GetResumeToken(ctx context.Context, stream string) ([]byte, error)
+	SaveResumeToken(ctx context.Context, stream string, token []byte) error
+
+	//Transactions
+	WithTransaction(ctx context.Context, fn func(tx Repository) error) error
+
+	//Ping checks the database is reachable
+	Ping(ctx context.Context) error
 
 	//Close the connection
 	Close() error
@@ -28,6 +92,16 @@ func SetRepository(repository Repository) {
 	implementation = repository
 }
 
+// Run fn atomically, every call made through tx is committed or rolled back together
+func WithTransaction(ctx context.Context, fn func(tx Repository) error) error {
+	return implementation.WithTransaction(ctx, fn)
+}
+
+// Ping checks the database is reachable
+func Ping(ctx context.Context) error {
+	return implementation.Ping(ctx)
+}
+
 // Close the connection
 func Close() error {
 	return implementation.Close()
@@ -10,6 +10,10 @@ func InsertUser(ctx context.Context, user *models.InsertUser) (*models.Profile,
 	return implementation.InsertUser(ctx, user)
 }
 
+func InsertUsers(ctx context.Context, users []*models.InsertUser) ([]models.Profile, error) {
+	return implementation.InsertUsers(ctx, users)
+}
+
 func GetUserById(ctx context.Context, id string) (*models.Profile, error) {
 	return implementation.GetUserById(ctx, id)
 }
@@ -18,6 +22,15 @@ func ListUsers(ctx context.Context) ([]models.Profile, error) {
 	return implementation.ListUsers(ctx)
 }
 
+// SearchUsers also returns how many users match, for pagination
+func SearchUsers(ctx context.Context, filter models.UserFilter) ([]models.Profile, int64, error) {
+	return implementation.SearchUsers(ctx, filter)
+}
+
+func EachUser(ctx context.Context, fn func(profile models.Profile) error) error {
+	return implementation.EachUser(ctx, fn)
+}
+
 func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	return implementation.GetUserByEmail(ctx, email)
 }
@@ -33,3 +46,7 @@ func DeleteUser(ctx context.Context, id string) error {
 func UpdateUserPassword(ctx context.Context, userId string, newPassword string) (profile *models.Profile, err error) {
 	return implementation.UpdateUserPassword(ctx, userId, newPassword)
 }
+
+func JoinOrganization(ctx context.Context, userId string, roles []string) (*models.Profile, error) {
+	return implementation.JoinOrganization(ctx, userId, roles)
+}
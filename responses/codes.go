@@ -0,0 +1,46 @@
+package responses
+
+import "net/http"
+
+// ErrorCode is an entry of the error catalog. Code is stable, clients can
+// branch on it, while titles and details are meant for people.
+type ErrorCode struct {
+	Code   string
+	Status int
+	Title  string
+}
+
+var (
+	CodeInvalidBody          = ErrorCode{"invalid_body", http.StatusBadRequest, "Invalid request body"}
+	CodeInvalidQuery         = ErrorCode{"invalid_query", http.StatusBadRequest, "Invalid query parameter"}
+	CodeValidationFailed     = ErrorCode{"validation_failed", http.StatusUnprocessableEntity, "Validation failed"}
+	CodeBodyTooLarge         = ErrorCode{"body_too_large", http.StatusRequestEntityTooLarge, "Request body too large"}
+	CodeUnsupportedMediaType = ErrorCode{"unsupported_media_type", http.StatusUnsupportedMediaType, "Unsupported media type"}
+	CodeUnauthorized         = ErrorCode{"unauthorized", http.StatusUnauthorized, "Missing or invalid token"}
+	CodeInvalidCredentials   = ErrorCode{"invalid_credentials", http.StatusUnauthorized, "Invalid credentials"}
+	CodeForbidden            = ErrorCode{"forbidden", http.StatusForbidden, "Permission denied"}
+	CodeCSRFFailed           = ErrorCode{"csrf_failed", http.StatusForbidden, "Missing or invalid CSRF token"}
+	CodeNotFound             = ErrorCode{"not_found", http.StatusNotFound, "Resource not found"}
+	CodeAlreadyExists        = ErrorCode{"already_exists", http.StatusConflict, "Resource already exists"}
+	CodeInvitationInvalid    = ErrorCode{"invitation_invalid", http.StatusGone, "Invalid or expired invitation"}
+	CodeMailFailed           = ErrorCode{"mail_failed", http.StatusBadGateway, "Email could not be sent"}
+	CodeInternal             = ErrorCode{"internal", http.StatusInternalServerError, "Internal Server Error"}
+)
+
+// Codes is the catalog, every code a response may carry
+var Codes = []ErrorCode{
+	CodeInvalidBody,
+	CodeInvalidQuery,
+	CodeValidationFailed,
+	CodeBodyTooLarge,
+	CodeUnsupportedMediaType,
+	CodeUnauthorized,
+	CodeInvalidCredentials,
+	CodeForbidden,
+	CodeCSRFFailed,
+	CodeNotFound,
+	CodeAlreadyExists,
+	CodeInvitationInvalid,
+	CodeMailFailed,
+	CodeInternal,
+}
@@ -0,0 +1,96 @@
+package responses
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/logging"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body, Code is the catalog entry
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError points at the field of the request that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Message before formatting, the key of its translations
+	format string
+	args   []interface{}
+}
+
+// NewFieldError formats the message like fmt.Sprintf, responses translate it
+func NewFieldError(field string, code string, format string, args ...interface{}) FieldError {
+	return FieldError{
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		format:  format,
+		args:    args,
+	}
+}
+
+// Translate returns the error with its message in locale
+func (e FieldError) Translate(locale string) FieldError {
+	if e.format != "" {
+		e.Message = i18n.T(locale, e.format, e.args...)
+	}
+	return e
+}
+
+// NewProblem writes the title and detail in the locale of the request
+func NewProblem(r *http.Request, code ErrorCode, detail string, args ...interface{}) Problem {
+	locale := i18n.Locale(r.Context())
+	problem := Problem{
+		Type:     "/problems/" + code.Code,
+		Title:    i18n.Title(locale, code.Code, code.Title),
+		Status:   code.Status,
+		Instance: logging.RequestId(r.Context()),
+		Code:     code.Code,
+	}
+	if detail != "" {
+		problem.Detail = i18n.T(locale, detail, args...)
+	}
+	return problem
+}
+
+// Error answers with the problem of the code, detail may be empty
+func Error(w http.ResponseWriter, r *http.Request, code ErrorCode, detail string) {
+	WriteProblem(w, r, NewProblem(r, code, detail))
+}
+
+// Errorf is Error with a detail formatted like fmt.Sprintf, the format is translated
+func Errorf(w http.ResponseWriter, r *http.Request, code ErrorCode, format string, args ...interface{}) {
+	WriteProblem(w, r, NewProblem(r, code, format, args...))
+}
+
+// ValidationError answers with every field that failed validation at once
+func ValidationError(w http.ResponseWriter, r *http.Request, errors []FieldError) {
+	locale := i18n.Locale(r.Context())
+	problem := NewProblem(r, CodeValidationFailed, "")
+	for _, fieldError := range errors {
+		problem.Errors = append(problem.Errors, fieldError.Translate(locale))
+	}
+	WriteProblem(w, r, problem)
+}
+
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("Content-Language", i18n.Locale(r.Context()))
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,66 @@
+package responses
+
+import (
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/versioning"
+)
+
+// ProfileV2 is models.Profile as /v2 represents it: the id is named id,
+// groups are always listed and memberships become organizations.
+type ProfileV2 struct {
+	Id            string                `json:"id"`
+	Name          string                `json:"name"`
+	Email         string                `json:"email"`
+	Roles         []string              `json:"roles"`
+	Groups        []string              `json:"groups"`
+	Organizations []OrganizationRolesV2 `json:"organizations,omitempty"`
+	Locale        string                `json:"locale,omitempty"`
+}
+
+type OrganizationRolesV2 struct {
+	Id    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+func NewProfileV2(profile models.Profile) ProfileV2 {
+	result := ProfileV2{
+		Id:     profile.Id.Hex(),
+		Name:   profile.Name,
+		Email:  profile.Email,
+		Roles:  profile.Roles,
+		Groups: profile.Groups,
+		Locale: profile.Locale,
+	}
+	if result.Roles == nil {
+		result.Roles = []string{}
+	}
+	if result.Groups == nil {
+		result.Groups = []string{}
+	}
+	for _, membership := range profile.Memberships {
+		result.Organizations = append(result.Organizations, OrganizationRolesV2{
+			Id:    membership.OrganizationId.Hex(),
+			Roles: membership.Roles,
+		})
+	}
+	return result
+}
+
+// Profile returns the representation of profile clients of version expect
+func Profile(version string, profile models.Profile) interface{} {
+	if version == versioning.V2 {
+		return NewProfileV2(profile)
+	}
+	return profile
+}
+
+func Profiles(version string, profiles []models.Profile) interface{} {
+	if version == versioning.V2 {
+		result := []ProfileV2{}
+		for _, profile := range profiles {
+			result = append(result, NewProfileV2(profile))
+		}
+		return result
+	}
+	return profiles
+}
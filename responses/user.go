@@ -2,5 +2,23 @@ package responses
 
 type LoginResponse struct {
 	Message string `json:"message"`
-	Token   string `json:"token"`
+	// Empty when the token went to the session cookie
+	Token string `json:"token,omitempty"`
+	// Value of the X-CSRF-Token header for cookie sessions
+	CSRFToken string `json:"csrfToken,omitempty"`
+}
+
+type ImportResponse struct {
+	Total   int              `json:"total"`
+	Created int              `json:"created"`
+	Invited int              `json:"invited"`
+	Failed  int              `json:"failed"`
+	DryRun  bool             `json:"dryRun"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/danielgz405/template-api-rest-go/responses"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recoverPanics turns a panic in a handler into a logged stack trace and a
+// JSON 500, instead of a dropped connection.
+func (b *Broker) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Sent on purpose to abort a response, net/http handles it quietly
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+			b.logger.ErrorContext(r.Context(), "Panic serving request",
+				"panic", recovered,
+				"method", r.Method,
+				"stack", string(debug.Stack()),
+			)
+			trace.SpanFromContext(r.Context()).SetStatus(codes.Error, "panic")
+
+			responses.Error(w, r, responses.CodeInternal, "")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
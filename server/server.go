@@ -3,32 +3,54 @@ package server
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/danielgz405/template-api-rest-go/config"
 	"github.com/danielgz405/template-api-rest-go/database"
+	"github.com/danielgz405/template-api-rest-go/health"
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/logging"
+	"github.com/danielgz405/template-api-rest-go/mailer"
+	"github.com/danielgz405/template-api-rest-go/metrics"
+	"github.com/danielgz405/template-api-rest-go/pages"
 	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/tracing"
 	"github.com/danielgz405/template-api-rest-go/websocket"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-type Config struct {
-	Port      string
-	JWTSecret string
-	DbURI     string
-}
+// Config is loaded and validated by the config package
+type Config = config.Config
 
 type Server interface {
 	Config() *Config
 	Hub() *websocket.Hub
+	Mailer() mailer.Mailer
+	Health() *health.Registry
+	Logger() *slog.Logger
+	Pages() *pages.Renderer
 }
 
 type Broker struct {
 	config *Config
 	router *mux.Router
 	hub    *websocket.Hub
+	mailer mailer.Mailer
+	health *health.Registry
+	logger *slog.Logger
+	pages  *pages.Renderer
+	// exports the spans still buffered, set by Start
+	flushTraces  func(ctx context.Context) error
+	watchTargets []websocket.WatchTarget
 }
 
 func (b *Broker) Config() *Config {
@@ -39,46 +61,297 @@ func (b *Broker) Hub() *websocket.Hub {
 	return b.hub
 }
 
+func (b *Broker) Mailer() mailer.Mailer {
+	return b.mailer
+}
+
+// Health holds the readiness checks, subsystems can register their own
+func (b *Broker) Health() *health.Registry {
+	return b.health
+}
+
+// Logger adds the request id of the context to records logged with one
+func (b *Broker) Logger() *slog.Logger {
+	return b.logger
+}
+
+// Pages renders the HTML pages
+func (b *Broker) Pages() *pages.Renderer {
+	return b.pages
+}
+
+// Watch registers collections whose changes are forwarded to the hub when WatchChanges is on
+func (b *Broker) Watch(targets ...websocket.WatchTarget) {
+	b.watchTargets = append(b.watchTargets, targets...)
+}
+
 func NewServer(ctx context.Context, config *Config) (*Broker, error) {
-	if config.Port == "" {
-		return nil, errors.New("port is required")
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
-	if config.JWTSecret == "" {
-		return nil, errors.New("jwt secret is required")
+	logger, err := logging.New(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		return nil, err
 	}
-	if config.DbURI == "" {
-		return nil, errors.New("database uri is required")
+	renderer, err := pages.New(config.PagesDevDir)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pages: %w", err)
 	}
 	broker := &Broker{
 		config: config,
 		router: mux.NewRouter(),
-		hub:    websocket.NewHub(),
+		hub: websocket.NewHub(websocket.HubOptions{
+			OriginatedWindow: config.OriginatedWindow,
+			AllowOrigin:      config.AllowsOrigin,
+			SessionCookie:    config.SessionCookieName,
+
+			AllowCredentialedOrigin: config.AllowsCredentialedOrigin,
+			SendBuffer:              config.WebsocketSendBuffer,
+			Modules:                 config.WebsocketModules,
+			Logger:                  logger,
+		}),
+		mailer: mailer.New(config.SMTPAddr, config.MailFrom, config.SMTPUsername, config.SMTPPassword, logger),
+		health: health.NewRegistry(logger),
+		logger: logger,
+		pages:  renderer,
 	}
 	return broker, nil
 }
 
-func (b *Broker) Start(binder func(s Server, r *mux.Router)) {
+// Start serves until ctx is done, then stops accepting connections, lets
+// in-flight requests finish within ShutdownTimeout, closes the websocket
+// clients and disconnects the database.
+func (b *Broker) Start(ctx context.Context, binder func(s Server, r *mux.Router)) error {
 	b.router = mux.NewRouter()
 	binder(b, b.router)
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedHeaders:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-		AllowCredentials: true,
+		AllowOriginFunc:  b.config.AllowsOrigin,
+		AllowedHeaders:   b.config.CorsAllowedHeaders,
+		AllowedMethods:   b.config.CorsAllowedMethods,
+		ExposedHeaders:   b.config.CorsExposedHeaders,
+		AllowCredentials: b.config.CorsAllowCredentials,
+		MaxAge:           int(b.config.CorsMaxAge.Seconds()),
 	})
 
+	if b.config.MetricsAddr == "" {
+		b.router.Handle("/metrics", metrics.Handler(b.config.MetricsToken)).Methods(http.MethodGet)
+	}
 	handler := c.Handler(b.router)
-	repo, err := database.NewMongoRepo(b.config.DbURI)
+	handler = b.recoverPanics(handler)
+	handler = i18n.Middleware(handler)
+	handler = metrics.Middleware(b.routeTemplate)(handler)
+	handler = logging.ClientIPMiddleware(b.config.TrustedProxyPrefixes())(handler)
+	handler = logging.Middleware(b.logger, b.routeTemplate)(handler)
+	// Outermost so the request logs and every span below share the incoming trace
+	handler = otelhttp.NewHandler(handler, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + b.routeTemplate(r)
+		}),
+	)
+	repo, err := database.NewMongoRepo(b.config.DbURI, b.config.DbName)
+	if err != nil {
+		return err
+	}
+	// Migrations, change streams and the hub work for the system across every
+	// organization, requests are scoped by the token of their user
+	ctx = tenant.Unscoped(ctx)
+	if b.config.AllowNonAtomicTransactions {
+		standalone, err := repo.AllowNonAtomicTransactions(ctx)
+		if err != nil {
+			repo.Close()
+			return fmt.Errorf("checking transaction support: %w", err)
+		}
+		if standalone {
+			b.logger.Warn("The database is a standalone server, transactions run their steps without atomicity")
+		}
+	}
+	b.flushTraces, err = tracing.Setup(ctx, tracing.Options{
+		Exporter:    b.config.TracingExporter,
+		ServiceName: b.config.TracingServiceName,
+		File:        b.config.TracingFile,
+		Endpoint:    b.config.TracingEndpoint,
+		Insecure:    b.config.TracingInsecure,
+		SampleRatio: b.config.TracingSampleRatio,
+	})
 	if err != nil {
-		log.Fatal(err)
+		repo.Close()
+		return err
 	}
 
-	go b.Hub().Run()
+	// A failed migration keeps the server unready instead of down
+	if err := repo.Migrate(ctx); err != nil {
+		b.logger.Error("Error applying migrations", "error", err)
+	}
+	b.health.Register("database", repository.Ping)
+	b.health.Register("migrations", func(ctx context.Context) error {
+		pending, err := repo.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("pending migrations: %v", pending)
+		}
+		return nil
+	})
+	b.health.Register("hub", func(ctx context.Context) error {
+		if !b.hub.Running() {
+			return errors.New("hub is not running")
+		}
+		return nil
+	})
+
+	var implementation repository.Repository = repo
+	if b.config.CacheTTL > 0 {
+		cached := repository.NewCachedRepository(implementation, repository.CacheOptions{
+			TTL:     b.config.CacheTTL,
+			MaxSize: b.config.CacheSize,
+		})
+		metrics.SetProfileCache(func() metrics.ProfileCacheStats {
+			return metrics.ProfileCacheStats(cached.Stats())
+		})
+		implementation = cached
+	}
+	implementation = repository.NewObservedRepository(implementation, metrics.ObserveRepository)
+	repository.SetRepository(repository.NewObservedRepository(implementation, tracing.ObserveRepository))
+
+	// Cancelled when the server stops for any reason, not only a signal
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		b.hub.Run(ctx)
+	}()
+
+	if b.config.WatchChanges && len(b.watchTargets) > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			websocket.NewWatcher(b.hub, b.config.WatchRetryDelay, b.watchTargets...).Run(ctx)
+		}()
+	}
+
+	servers := []*http.Server{}
+	serveErr := make(chan error, 3)
+	if b.config.MetricsAddr != "" {
+		metricsServer := &http.Server{
+			Addr:    b.config.MetricsAddr,
+			Handler: metrics.Handler(b.config.MetricsToken),
+		}
+		servers = append(servers, metricsServer)
+		go func() {
+			serveErr <- metricsServer.ListenAndServe()
+		}()
+		b.logger.Info("Serving metrics", "addr", b.config.MetricsAddr)
+	}
+	if b.config.TLSEnabled() {
+		reloader, err := newCertReloader(b.config.TLSCertFile, b.config.TLSKeyFile)
+		if err != nil {
+			return b.stop(cancel, &background, err)
+		}
+		tlsConfig, err := b.tlsConfig(reloader)
+		if err != nil {
+			return b.stop(cancel, &background, err)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			reloader.watch(ctx, b.config.TLSReloadInterval, b.logger)
+		}()
+		if b.config.HSTSMaxAge > 0 {
+			handler = b.withHSTS(handler)
+		}
+		httpsServer := &http.Server{
+			Addr:      b.config.Port,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		}
+		servers = append(servers, httpsServer)
+		go func() {
+			// The certificate comes from TLSConfig
+			serveErr <- httpsServer.ListenAndServeTLS("", "")
+		}()
+		b.logger.Info("Server started with tls", "addr", b.config.Port)
+
+		if b.config.TLSRedirectAddr != "" {
+			redirectServer := &http.Server{
+				Addr:    b.config.TLSRedirectAddr,
+				Handler: b.redirectHandler(),
+			}
+			servers = append(servers, redirectServer)
+			go func() {
+				serveErr <- redirectServer.ListenAndServe()
+			}()
+			b.logger.Info("Redirecting http to https", "addr", b.config.TLSRedirectAddr)
+		}
+	} else {
+		httpServer := &http.Server{
+			Addr:    b.config.Port,
+			Handler: handler,
+		}
+		servers = append(servers, httpServer)
+		go func() {
+			serveErr <- httpServer.ListenAndServe()
+		}()
+		b.logger.Info("Server started", "addr", b.config.Port)
+	}
+
+	select {
+	case err = <-serveErr:
+		b.logger.Error("Server failed", "error", err)
+		b.health.SetShuttingDown()
+	case <-ctx.Done():
+		// Fail readiness while still serving, the load balancers need a few
+		// probes to stop routing here before connections are refused
+		b.health.SetShuttingDown()
+		b.logger.Info("Shutting down", "drain_delay", b.config.ShutdownDrainDelay)
+		select {
+		case err = <-serveErr:
+			b.logger.Error("Server failed", "error", err)
+		case <-time.After(b.config.ShutdownDrainDelay):
+		}
+	}
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), b.config.ShutdownTimeout)
+	defer cancelDrain()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(drainCtx); shutdownErr != nil {
+			b.logger.Error("Error draining requests", "error", shutdownErr)
+			if err == nil {
+				err = shutdownErr
+			}
+		}
+	}
+	return b.stop(cancel, &background, err)
+}
 
-	repository.SetRepository(repo)
+// stop ends the background work started by Start and disconnects the database
+func (b *Broker) stop(cancel context.CancelFunc, background *sync.WaitGroup, err error) error {
+	// Websocket connections are hijacked so Shutdown leaves them to the hub
+	cancel()
+	background.Wait()
+	if closeErr := repository.Close(); closeErr != nil {
+		b.logger.Error("Error closing the database", "error", closeErr)
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), b.config.ShutdownTimeout)
+	defer cancelFlush()
+	if flushErr := b.flushTraces(flushCtx); flushErr != nil {
+		b.logger.Error("Error flushing traces", "error", flushErr)
+	}
+	b.logger.Info("Server stopped")
+	return err
+}
 
-	log.Println("Server started on port", b.config.Port)
-	if err := http.ListenAndServe(b.config.Port, handler); err != nil {
-		log.Fatal("Server failed to start", err)
+// routeTemplate names the route the request matches, /user/update/{id} rather than the path
+func (b *Broker) routeTemplate(r *http.Request) string {
+	var match mux.RouteMatch
+	if !b.router.Match(r, &match) || match.Route == nil {
+		return "unmatched"
+	}
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
 	}
+	return template
 }
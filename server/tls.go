@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate on disk, picking up renewals without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload loads the pair again when either file changed, a broken pair keeps the current one
+func (reloader *certReloader) reload() (bool, error) {
+	modified, err := latestModTime(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+	reloader.mutex.RLock()
+	unchanged := reloader.cert != nil && modified.Equal(reloader.modified)
+	reloader.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	reloader.cert = &cert
+	reloader.modified = modified
+	return true, nil
+}
+
+// watch checks the files every interval until ctx is done
+func (reloader *certReloader) watch(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.reload()
+			if err != nil {
+				logger.Error("Error reloading the tls certificate, keeping the current one", "error", err)
+			} else if reloaded {
+				logger.Info("Reloaded the tls certificate")
+			}
+		}
+	}
+}
+
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// tlsConfig builds the server side tls settings, h2 is offered before http/1.1
+func (b *Broker) tlsConfig(reloader *certReloader) (*tls.Config, error) {
+	minVersion, err := tlsVersion(b.config.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := cipherSuites(b.config.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
+}
+
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls version %q", version)
+}
+
+// cipherSuites maps names like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, an empty list keeps go's defaults
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// withHSTS tells browsers to only use https for this host
+func (b *Broker) withHSTS(next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(b.config.HSTSMaxAge.Seconds()))
+	if b.config.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectHandler sends plain http requests to the same url on the https port
+func (b *Broker) redirectHandler() http.Handler {
+	_, port, _ := net.SplitHostPort(b.config.Port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
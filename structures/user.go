@@ -1,20 +1,36 @@
 package structures
 
+// Requests are checked against their validate tags, see the validation package
+
 type CreateRequest struct {
-	Email    string   `json:"email"`
-	Password string   `json:"password"`
-	Name     string   `json:"name"`
-	Roles    []string `json:"roles"`
+	Email string `json:"email" validate:"required,email,max=254"`
+	// bcrypt rejects anything past 72 bytes
+	Password string   `json:"password" validate:"required,min=8,maxbytes=72"`
+	Name     string   `json:"name" validate:"required,max=100"`
+	Roles    []string `json:"roles" validate:"max=10,dive,enum=roles"`
+	Locale   string   `json:"locale" validate:"enum=locales"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	// Optional, members default to their first organization
+	Organization string `json:"organization"`
+	// Browsers may ask for a session cookie instead of getting the token
+	Cookie bool `json:"cookie"`
 }
 
+// Updates are partial, empty fields are left as they are
 type UpdateUserRequest struct {
-	Name  string   `json:"name"`
-	Roles []string `json:"roles"`
+	Name   string   `json:"name" validate:"max=100"`
+	Roles  []string `json:"roles" validate:"max=10,dive,enum=roles"`
+	Locale string   `json:"locale" validate:"enum=locales"`
+}
+
+// Users change their own name and preferred language, never their roles
+type UpdateProfileRequest struct {
+	Name   string `json:"name" validate:"max=100"`
+	Locale string `json:"locale" validate:"enum=locales"`
 }
 
 type ProfileRequest struct {
@@ -23,3 +39,38 @@ type ProfileRequest struct {
 	Email string   `bson:"email" json:"email"`
 	Roles []string `bson:"roles" json:"roles"`
 }
+
+// Name and password are only required when the rows aren't invited
+type ImportUserRow struct {
+	Email    string   `json:"email" validate:"required,email,max=254"`
+	Password string   `json:"password" validate:"min=8,maxbytes=72"`
+	Name     string   `json:"name" validate:"max=100"`
+	Roles    []string `json:"roles" validate:"max=10,dive,enum=roles"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type CreateInvitationRequest struct {
+	Email string   `json:"email" validate:"required,email,max=254"`
+	Roles []string `json:"roles" validate:"max=10,dive,enum=roles"`
+}
+
+// Name and password are only required when the invited email has no account
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Name     string `json:"name" validate:"max=100"`
+	Password string `json:"password" validate:"min=8,maxbytes=72"`
+}
+
+type CreateGroupRequest struct {
+	Name    string   `json:"name" validate:"required,max=100"`
+	Roles   []string `json:"roles" validate:"max=10,dive,enum=roles"`
+	Members []string `json:"members"`
+}
+
+type UpdateGroupRequest struct {
+	Name  string   `json:"name" validate:"max=100"`
+	Roles []string `json:"roles" validate:"max=10,dive,enum=roles"`
+}
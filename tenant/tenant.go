@@ -0,0 +1,79 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Users with this global role act across every organization
+const PlatformAdminRole = "platform_admin"
+
+// ErrNoTenantScope is returned for contexts that were neither scoped to an
+// organization nor marked Unscoped, so a forgotten scope fails instead of
+// reaching every tenant.
+var ErrNoTenantScope = errors.New("the context has no tenant scope")
+
+type contextKey struct{}
+
+type scope struct {
+	organizationId string
+	unscoped       bool
+}
+
+// WithOrganization scopes every repository call made with the context to one organization
+func WithOrganization(ctx context.Context, organizationId string) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{organizationId: organizationId})
+}
+
+// Unscoped lifts the organization scope, it's meant for platform admins and
+// system work such as logins and change streams.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, scope{unscoped: true})
+}
+
+// Organization returns the organization the context is scoped to, scoped is
+// false when Unscoped and ErrNoTenantScope is returned when it has no scope.
+func Organization(ctx context.Context) (organizationId string, scoped bool, err error) {
+	s, found := ctx.Value(contextKey{}).(scope)
+	if !found {
+		return "", false, ErrNoTenantScope
+	}
+	if s.unscoped {
+		return "", false, nil
+	}
+	return s.organizationId, true, nil
+}
+
+// Key identifies the scope of ctx, used to keep per tenant data apart in
+// caches. Contexts without a scope share an empty key nothing is loaded for.
+func Key(ctx context.Context) string {
+	organizationId, scoped, err := Organization(ctx)
+	if err != nil {
+		return ""
+	}
+	if scoped {
+		return organizationId
+	}
+	return "*"
+}
+
+// ForUser scopes ctx for a user who signed in to organizationId. Platform
+// admins signed in without an organization are unscoped, anyone else without
+// one is kept in an empty organization so they can't see other tenants.
+func ForUser(ctx context.Context, organizationId primitive.ObjectID, roles []string) context.Context {
+	if organizationId.IsZero() && IsPlatformAdmin(roles) {
+		return Unscoped(ctx)
+	}
+	return WithOrganization(ctx, organizationId.Hex())
+}
+
+func IsPlatformAdmin(roles []string) bool {
+	for _, role := range roles {
+		if role == PlatformAdminRole {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+// Package validation checks request structs against the rules in their
+// validate tags and reports every failing field at once.
+//
+//	Email string   `json:"email" validate:"required,email,max=254"`
+//	Roles []string `json:"roles" validate:"max=10,dive,enum=roles"`
+//
+// Rules are required, email, min=N, max=N (runes for strings, items for
+// slices), maxbytes=N (UTF-8 bytes of strings), oneof=a b c and enum=name, the values registered with
+// RegisterEnum; enum=locales is registered with the i18n locales. dive applies the rules after it to every item of a slice.
+// Fields are named after their json key.
+package validation
+
+import (
+	"fmt"
+	"maps"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/danielgz405/template-api-rest-go/i18n"
+	"github.com/danielgz405/template-api-rest-go/responses"
+)
+
+var (
+	enums      = map[string][]string{}
+	enumsMutex sync.RWMutex
+)
+
+func init() {
+	RegisterEnum("locales", i18n.Supported()...)
+}
+
+// RegisterEnum sets the values the enum=name rule accepts
+func RegisterEnum(name string, values ...string) {
+	enumsMutex.Lock()
+	defer enumsMutex.Unlock()
+	enums[name] = values
+}
+
+// Enum returns the values of a registered enum, for documentation
+func Enum(name string) ([]string, bool) {
+	enumsMutex.RLock()
+	defer enumsMutex.RUnlock()
+	values, ok := enums[name]
+	return values, ok
+}
+
+// Struct validates v, a struct or a pointer to one, nil means it is valid
+func Struct(v interface{}) []responses.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic("validation: Struct needs a struct, got " + value.Kind().String())
+	}
+	var errors []responses.FieldError
+	fieldType := value.Type()
+	for i := 0; i < fieldType.NumField(); i++ {
+		field := fieldType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		errors = append(errors, check(jsonName(field), value.Field(i), strings.Split(tag, ","))...)
+	}
+	return errors
+}
+
+// Required reports the empty values by field name, for fields whose rules
+// depend on more than the request
+func Required(values map[string]string) []responses.FieldError {
+	var errors []responses.FieldError
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if format, args := apply("required", "", reflect.ValueOf(values[name])); format != "" {
+			errors = append(errors, responses.NewFieldError(name, "required", format, args...))
+		}
+	}
+	return errors
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func check(name string, value reflect.Value, rules []string) []responses.FieldError {
+	for i, rule := range rules {
+		if rule == "dive" {
+			var errors []responses.FieldError
+			for j := 0; j < value.Len(); j++ {
+				errors = append(errors, check(fmt.Sprintf("%s[%d]", name, j), value.Index(j), rules[i+1:])...)
+			}
+			return errors
+		}
+		rule, argument, _ := strings.Cut(rule, "=")
+		// Optional fields are only checked when set
+		if rule != "required" && value.IsZero() {
+			continue
+		}
+		if format, args := apply(rule, argument, value); format != "" {
+			return []responses.FieldError{responses.NewFieldError(name, rule, format, args...)}
+		}
+	}
+	return nil
+}
+
+// apply returns why value breaks the rule, as a format and its arguments, or an empty string
+func apply(rule string, argument string, value reflect.Value) (string, []interface{}) {
+	switch rule {
+	case "required":
+		if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+			return "is required", nil
+		}
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be a valid email address", nil
+		}
+	case "min", "max":
+		limit, err := strconv.Atoi(argument)
+		if err != nil {
+			panic("validation: " + rule + " needs a number, got " + argument)
+		}
+		if value.Kind() == reflect.String {
+			length := utf8.RuneCountInString(value.String())
+			if rule == "min" && length < limit {
+				return "must have at least %d characters", []interface{}{limit}
+			}
+			if rule == "max" && length > limit {
+				return "must have at most %d characters", []interface{}{limit}
+			}
+		} else {
+			if rule == "min" && value.Len() < limit {
+				return "must have at least %d items", []interface{}{limit}
+			}
+			if rule == "max" && value.Len() > limit {
+				return "must have at most %d items", []interface{}{limit}
+			}
+		}
+	case "maxbytes":
+		limit, err := strconv.Atoi(argument)
+		if err != nil {
+			panic("validation: maxbytes needs a number, got " + argument)
+		}
+		if len(value.String()) > limit {
+			return "must have at most %d bytes", []interface{}{limit}
+		}
+	case "oneof":
+		return oneOf(value.String(), strings.Fields(argument))
+	case "enum":
+		enumsMutex.RLock()
+		values, ok := enums[argument]
+		enumsMutex.RUnlock()
+		if !ok {
+			panic("validation: unknown enum " + argument)
+		}
+		return oneOf(value.String(), values)
+	default:
+		panic("validation: unknown rule " + rule)
+	}
+	return "", nil
+}
+
+func oneOf(value string, values []string) (string, []interface{}) {
+	for _, v := range values {
+		if value == v {
+			return "", nil
+		}
+	}
+	return "must be one of: %s", []interface{}{strings.Join(values, ", ")}
+}
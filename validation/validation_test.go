@@ -0,0 +1,102 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danielgz405/template-api-rest-go/responses"
+)
+
+func init() {
+	RegisterEnum("colors", "red", "green")
+}
+
+type limits struct {
+	Name     string   `json:"name" validate:"required,max=5"`
+	Password string   `json:"password" validate:"min=3,maxbytes=6"`
+	Tags     []string `json:"tags" validate:"max=2,dive,max=3"`
+	Colors   []string `json:"colors" validate:"dive,enum=colors"`
+	Color    string   `json:"color" validate:"enum=colors"`
+	Size     string   `json:"size" validate:"oneof=s m l"`
+}
+
+// failures returns field:code for every error
+func failures(errors []responses.FieldError) []string {
+	result := []string{}
+	for _, fieldError := range errors {
+		result = append(result, fieldError.Field+":"+fieldError.Code)
+	}
+	return result
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name  string
+		value limits
+		want  []string
+	}{
+		{"valid", limits{Name: "ana", Password: "secret", Tags: []string{"a", "b"}, Colors: []string{"red"}, Color: "green", Size: "m"}, []string{}},
+		{"optional fields unset", limits{Name: "ana"}, []string{}},
+		{"required missing", limits{}, []string{"name:required"}},
+		{"required blank", limits{Name: "   "}, []string{"name:required"}},
+		{"max counts runes", limits{Name: "ñandú"}, []string{}},
+		{"max over in runes", limits{Name: "ñandúes"}, []string{"name:max"}},
+		{"maxbytes counts bytes", limits{Name: "ana", Password: "ñññ"}, []string{}},
+		{"maxbytes over in bytes within runes", limits{Name: "ana", Password: "ñññña"}, []string{"password:maxbytes"}},
+		{"min counts runes", limits{Name: "ana", Password: "ññ"}, []string{"password:min"}},
+		{"too many items", limits{Name: "ana", Tags: []string{"a", "b", "c"}}, []string{"tags:max"}},
+		{"dive checks every item", limits{Name: "ana", Tags: []string{"abcd", "ñañá"}}, []string{"tags[0]:max", "tags[1]:max"}},
+		{"dive max counts runes", limits{Name: "ana", Tags: []string{"ñañ"}}, []string{}},
+		{"dive enum", limits{Name: "ana", Colors: []string{"red", "blue"}}, []string{"colors[1]:enum"}},
+		{"enum", limits{Name: "ana", Color: "blue"}, []string{"color:enum"}},
+		{"oneof", limits{Name: "ana", Size: "xl"}, []string{"size:oneof"}},
+		{"every field at once", limits{Password: "ab", Color: "blue", Size: "xl"}, []string{"name:required", "password:min", "color:enum", "size:oneof"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := failures(Struct(test.value))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Struct(%+v) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestStructMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		value limits
+		want  string
+	}{
+		{"max", limits{Name: "abcdef"}, "must have at most 5 characters"},
+		{"maxbytes", limits{Name: "ana", Password: "ññññ"}, "must have at most 6 bytes"},
+		{"min", limits{Name: "ana", Password: "ab"}, "must have at least 3 characters"},
+		{"items", limits{Name: "ana", Tags: []string{"a", "b", "c"}}, "must have at most 2 items"},
+		{"enum", limits{Name: "ana", Color: "blue"}, "must be one of: red, green"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errors := Struct(test.value)
+			if len(errors) != 1 {
+				t.Fatalf("Struct(%+v) = %v, want one error", test.value, errors)
+			}
+			if errors[0].Message != test.want {
+				t.Errorf("message = %q, want %q", errors[0].Message, test.want)
+			}
+		})
+	}
+}
+
+func TestPasswordFitsBcrypt(t *testing.T) {
+	type request struct {
+		Password string `json:"password" validate:"required,min=8,maxbytes=72"`
+	}
+	// 72 bytes pass, one more multi-byte rune doesn't even though it's 37 runes
+	if errors := Struct(request{Password: strings.Repeat("a", 72)}); len(errors) != 0 {
+		t.Errorf("72 bytes: %v", errors)
+	}
+	if errors := Struct(request{Password: strings.Repeat("ñ", 37)}); len(errors) != 1 || errors[0].Code != "maxbytes" {
+		t.Errorf("74 bytes in 37 runes: %v", errors)
+	}
+}
@@ -0,0 +1,76 @@
+// Package versioning groups the API routes by version. Each version is
+// mounted under its own prefix, /v1 or /v2, and the request context names
+// the version so handlers pick the representation clients of that version
+// expect. Deprecated versions announce it in the Deprecation, Sunset and
+// Link headers.
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/metrics"
+)
+
+const (
+	V1 = "v1"
+	V2 = "v2"
+	// Paths without a version prefix predate versioning and answer as V1
+	Unversioned = V1
+)
+
+type Version struct {
+	Name string
+	// When the version was deprecated, zero while it is supported
+	Deprecated time.Time
+	// When the version may be removed, zero until that is decided
+	Sunset time.Time
+	// Version replacing a deprecated one
+	Successor string
+}
+
+type versionKey struct{}
+
+func WithVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// Current returns the version of the request, Unversioned outside the API routes
+func Current(ctx context.Context) string {
+	if version, ok := ctx.Value(versionKey{}).(string); ok {
+		return version
+	}
+	return Unversioned
+}
+
+// Middleware names the version in the context of every request, counts it
+// and adds the deprecation headers
+func (version Version) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, prefixed := strings.CutPrefix(r.URL.Path, "/"+version.Name+"/")
+		if r.URL.Path == "/"+version.Name {
+			rest, prefixed = "", true
+		}
+		path := "versioned"
+		if !prefixed {
+			rest = strings.TrimPrefix(r.URL.Path, "/")
+			path = "unversioned"
+		}
+		metrics.APIVersionRequests.WithLabelValues(version.Name, path).Inc()
+
+		if !version.Deprecated.IsZero() {
+			// RFC 9745 and RFC 8594
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", version.Deprecated.Unix()))
+			if !version.Sunset.IsZero() {
+				w.Header().Set("Sunset", version.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if version.Successor != "" {
+				w.Header().Add("Link", fmt.Sprintf(`</%s/%s>; rel="successor-version"`, version.Successor, rest))
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(WithVersion(r.Context(), version.Name)))
+	})
+}
@@ -0,0 +1,119 @@
+package versioning
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	deprecated := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		version     Version
+		path        string
+		deprecation string
+		sunset      string
+		link        string
+	}{
+		{
+			name:    "supported version",
+			version: Version{Name: V2},
+			path:    "/v2/user/profile",
+		},
+		{
+			name:        "deprecated version",
+			version:     Version{Name: V1, Deprecated: deprecated, Successor: V2},
+			path:        "/v1/user/profile",
+			deprecation: "@1792368000",
+			link:        `</v2/user/profile>; rel="successor-version"`,
+		},
+		{
+			name:        "deprecated version with sunset",
+			version:     Version{Name: V1, Deprecated: deprecated, Sunset: sunset, Successor: V2},
+			path:        "/v1/user/profile",
+			deprecation: "@1792368000",
+			sunset:      "Fri, 30 Apr 2027 00:00:00 GMT",
+			link:        `</v2/user/profile>; rel="successor-version"`,
+		},
+		{
+			name:        "sunset in another zone",
+			version:     Version{Name: V1, Deprecated: deprecated, Sunset: sunset.In(time.FixedZone("UTC-5", -5*60*60))},
+			path:        "/v1/user/profile",
+			deprecation: "@1792368000",
+			sunset:      "Fri, 30 Apr 2027 00:00:00 GMT",
+		},
+		{
+			name:        "unversioned path",
+			version:     Version{Name: V1, Deprecated: deprecated, Successor: V2},
+			path:        "/user/profile",
+			deprecation: "@1792368000",
+			link:        `</v2/user/profile>; rel="successor-version"`,
+		},
+		{
+			name:        "version root",
+			version:     Version{Name: V1, Deprecated: deprecated, Successor: V2},
+			path:        "/v1",
+			deprecation: "@1792368000",
+			link:        `</v2/>; rel="successor-version"`,
+		},
+		{
+			name:        "sunset without deprecation",
+			version:     Version{Name: V2, Sunset: sunset},
+			path:        "/v2/user/profile",
+			deprecation: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var current string
+			handler := test.version.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				current = Current(r.Context())
+			}))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if current != test.version.Name {
+				t.Errorf("Current = %q, want %q", current, test.version.Name)
+			}
+			headers := map[string]string{"Deprecation": test.deprecation, "Sunset": test.sunset, "Link": test.link}
+			for name, want := range headers {
+				if got := recorder.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareCountsRequests(t *testing.T) {
+	handler := Version{Name: V1}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	versioned := testutil.ToFloat64(metrics.APIVersionRequests.WithLabelValues(V1, "versioned"))
+	unversioned := testutil.ToFloat64(metrics.APIVersionRequests.WithLabelValues(V1, "unversioned"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/user/profile", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/profile", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/profile", nil))
+
+	if got := testutil.ToFloat64(metrics.APIVersionRequests.WithLabelValues(V1, "versioned")) - versioned; got != 1 {
+		t.Errorf("versioned requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.APIVersionRequests.WithLabelValues(V1, "unversioned")) - unversioned; got != 2 {
+		t.Errorf("unversioned requests = %v, want 2", got)
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := Current(request.Context()); got != Unversioned {
+		t.Errorf("Current without a version = %q, want %q", got, Unversioned)
+	}
+	if got := Current(WithVersion(request.Context(), V2)); got != V2 {
+		t.Errorf("Current = %q, want %q", got, V2)
+	}
+}
@@ -1,53 +1,84 @@
 package websocket
 
 import (
-	"github.com/gorilla/mux"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
-type Config struct {
-	Port      string
-	JWTSecret string
-	DbURI     string
-}
-
-type Server interface {
-	Config() *Config
-	Hub() *Hub
-}
-
-type Broker struct {
-	config *Config
-	router *mux.Router
-	hub    *Hub
-}
+// How long writing the close frame may take before the socket is dropped
+const closeWriteWait = time.Second
 
 type Client struct {
-	hub      *Hub
-	id       string
-	roles    []string
-	module   string
-	socket   *websocket.Conn
-	outbound chan []byte
+	hub    *Hub
+	id     string
+	roles  []string
+	groups []string
+	module string
+	// organization the client signed in to, unscoped platform clients get every message
+	organization string
+	scoped       bool
+	socket       *websocket.Conn
+	outbound     chan []byte
+	// close frame Write sends once outbound is closed, set by stop
+	closeCode   int
+	closeReason string
+	// shown by Clients
+	userId      string
+	name        string
+	connectedAt time.Time
 }
 
-func NewClient(hub *Hub, socket *websocket.Conn) *Client {
+// NewClient queues up to sendBuffer messages while the socket is busy
+func NewClient(hub *Hub, socket *websocket.Conn, sendBuffer int) *Client {
 	return &Client{
 		hub:      hub,
 		socket:   socket,
-		outbound: make(chan []byte),
+		outbound: make(chan []byte, sendBuffer),
+
+		connectedAt: time.Now(),
 	}
 }
 
+// stop makes Write send the close frame and close the socket, the hub calls
+// it once with its mutex held
+func (c *Client) stop(code int, reason string) {
+	c.closeCode = code
+	c.closeReason = reason
+	close(c.outbound)
+}
+
+// Read discards incoming messages until the connection fails, then unregisters the client
+func (c *Client) Read() {
+	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+	}()
+	defer c.hub.recoverPanic("read")
+	for {
+		if _, _, err := c.socket.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Write sends queued messages, a failed write closes the socket so Read unregisters the client
 func (c *Client) Write() {
+	defer c.hub.recoverPanic("write")
 	for {
 		select {
 		case message, ok := <-c.outbound:
 			if !ok {
-				c.socket.WriteMessage(websocket.CloseMessage, []byte{})
+				message := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				c.socket.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeWriteWait))
+				c.socket.Close()
 				return
 			}
-			c.socket.WriteMessage(websocket.TextMessage, message)
+			if err := c.socket.WriteMessage(websocket.TextMessage, message); err != nil {
+				c.socket.Close()
+			}
 		}
 	}
 }
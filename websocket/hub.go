@@ -3,147 +3,429 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/danielgz405/template-api-rest-go/logging"
+	"github.com/danielgz405/template-api-rest-go/metrics"
 	"github.com/danielgz405/template-api-rest-go/models"
 	"github.com/danielgz405/template-api-rest-go/repository"
+	"github.com/danielgz405/template-api-rest-go/tenant"
+	"github.com/danielgz405/template-api-rest-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		//Validate if the user is authorized to make requests, it's important to work with the token.
-		return true
-	},
-}
-
 type Hub struct {
 	clients    []*Client
 	register   chan *Client
 	unregister chan *Client
 	mutex      *sync.Mutex
+	upgrader   websocket.Upgrader
+	// handshakes authenticated by the session cookie, see HubOptions
+	sessionCookie           string
+	allowCredentialedOrigin func(origin string) bool
+	logger                  *slog.Logger
+	sendBuffer              int
+	modules                 map[string]bool
+	// closed when Run returns, stops connections from waiting on a hub that is gone
+	done    chan struct{}
+	running atomic.Bool
+
+	// documents whose change was already broadcast by a handler, for originatedWindow
+	originated       map[string]time.Time
+	originatedMutex  sync.Mutex
+	originatedWindow time.Duration
 }
 
-func NewHub() *Hub {
+type HubOptions struct {
+	// How long a handler broadcast suppresses the matching change event
+	OriginatedWindow time.Duration
+	// Origin policy of the handshake, the same one CORS applies
+	AllowOrigin func(origin string) bool
+	// Cookie holding the login token when the path doesn't carry one.
+	// Browsers send it whatever site opens the socket, so cookie handshakes
+	// must come from the same origin or one AllowCredentialedOrigin accepts.
+	SessionCookie           string
+	AllowCredentialedOrigin func(origin string) bool
+	// Messages queued per client, a client that falls further behind misses messages
+	SendBuffer int
+	// Known modules, metrics count the rest as "other"
+	Modules []string
+	Logger  *slog.Logger
+}
+
+func NewHub(options HubOptions) *Hub {
+	modules := make(map[string]bool, len(options.Modules))
+	for _, module := range options.Modules {
+		modules[module] = true
+	}
 	return &Hub{
 		clients:    make([]*Client, 0),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		mutex:      &sync.Mutex{},
+		logger:     options.Logger,
+		sendBuffer: options.SendBuffer,
+		modules:    modules,
+
+		sessionCookie:           options.SessionCookie,
+		allowCredentialedOrigin: options.AllowCredentialedOrigin,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return options.AllowOrigin(r.Header.Get("Origin"))
+			},
+		},
+		done:       make(chan struct{}),
+		originated: make(map[string]time.Time),
+
+		originatedWindow: options.OriginatedWindow,
 	}
 }
 
 func (hub *Hub) HandleWebSocket(JWTSecret string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		socket, err := upgrader.Upgrade(w, r, nil)
+		// Get the value of the parameter sent in the URL, or the session cookie
+		params := mux.Vars(r)
+		tokenString := strings.TrimSpace(params["Authorization"])
+		if tokenString == "" && hub.sessionCookie != "" {
+			if cookie, err := r.Cookie(hub.sessionCookie); err == nil {
+				if !sameOrigin(r) && !hub.allowCredentialedOrigin(r.Header.Get("Origin")) {
+					http.Error(w, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				tokenString = cookie.Value
+			}
+		}
+
+		socket, err := hub.upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			http.Error(w, "Could not open websocket connection", http.StatusBadRequest)
+			// Upgrade already answered with the error
+			return
 		}
-		client := NewClient(hub, socket)
+		client := NewClient(hub, socket, hub.sendBuffer)
 
-		// Get the value of the parameter sent in the URL
-		params := mux.Vars(r)
-		tokenString := strings.TrimSpace(params["Authorization"])
-		profile, err := ValidateTokenAndGetProfile(JWTSecret, tokenString, r.Context())
+		profile, ctx, err := ValidateTokenAndGetProfile(JWTSecret, tokenString, r.Context())
+		if err == nil {
+			client.organization, client.scoped, err = tenant.Organization(ctx)
+		}
 		if err != nil {
-			http.Error(w, "Error validating token", http.StatusUnauthorized)
+			// The connection is already upgraded, only a close frame can tell the client
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Error validating token")
+			socket.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeWriteWait))
+			socket.Close()
 			return
 		}
 		client.id = tokenString
+		client.userId = profile.Id.Hex()
+		client.name = profile.Name
 		client.roles = profile.Roles
+		client.groups = profile.Groups
 		client.module = params["Module"]
 
-		hub.register <- client
-
-		go func() {
-			for {
-				_, _, err := client.socket.ReadMessage()
-				if err != nil {
-					hub.unregister <- client
-					break
-				}
-			}
-		}()
+		select {
+		case hub.register <- client:
+		case <-hub.done:
+			socket.Close()
+			return
+		}
 
+		go client.Read()
 		go client.Write()
 	}
 }
-func (hub *Hub) Run() {
+
+// sameOrigin tells if the handshake comes from a page of this server,
+// requests without an Origin header don't come from a browser
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Run handles connections until ctx is done, then sends every client a close frame
+func (hub *Hub) Run(ctx context.Context) {
+	hub.running.Store(true)
+	defer hub.running.Store(false)
 	for {
 		select {
 		case client := <-hub.register:
-			hub.onConnect(client)
+			hub.safely("connect", func() { hub.onConnect(client) })
 		case client := <-hub.unregister:
-			hub.onDisconnect(client)
+			hub.safely("disconnect", func() { hub.onDisconnect(client) })
+		case <-ctx.Done():
+			hub.shutdown()
+			return
 		}
 	}
 }
 
-func (hub *Hub) onConnect(client *Client) {
-	fmt.Println("Connecting")
+// safely runs fn so a panic handling one client doesn't stop the hub
+func (hub *Hub) safely(operation string, fn func()) {
+	defer hub.recoverPanic(operation)
+	fn()
+}
+
+// recoverPanic must be deferred directly, it logs the panic with its stack
+func (hub *Hub) recoverPanic(operation string) {
+	if recovered := recover(); recovered != nil {
+		hub.logger.Error("Websocket panic recovered", "operation", operation, "panic", recovered, "stack", string(debug.Stack()))
+	}
+}
+
+// Running reports whether Run is handling connections
+func (hub *Hub) Running() bool {
+	return hub.running.Load()
+}
+
+func (hub *Hub) shutdown() {
+	close(hub.done)
 
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for _, client := range hub.clients {
+		client.stop(websocket.CloseGoingAway, "server shutting down")
+		metrics.WebsocketClients.WithLabelValues(hub.moduleLabel(client.module)).Dec()
+	}
+	hub.clients = nil
+}
+
+// moduleLabel keeps the module label of the metrics to the known modules,
+// the module comes from the path and could be anything
+func (hub *Hub) moduleLabel(module string) string {
+	if hub.modules[module] {
+		return module
+	}
+	return "other"
+}
+
+func (hub *Hub) onConnect(client *Client) {
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
 	hub.clients = append(hub.clients, client)
+	metrics.WebsocketClients.WithLabelValues(hub.moduleLabel(client.module)).Inc()
+	hub.logger.Debug("Websocket connected", "module", client.module, "organization", client.organization, "clients", len(hub.clients))
 }
 
 func (hub *Hub) onDisconnect(client *Client) {
-	fmt.Println("Disconnecting")
-
 	client.socket.Close()
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
 
+	// Several connections may share a token, only this one leaves
 	i := -1
 	for j, c := range hub.clients {
-		if c.id == client.id {
+		if c == client {
 			i = j
 		}
 	}
+	if i == -1 {
+		// Already gone, the hub shut down or the client was removed before
+		return
+	}
 
 	copy(hub.clients[i:], hub.clients[i+1:])
 	hub.clients[len(hub.clients)-1] = nil
 	hub.clients = hub.clients[:len(hub.clients)-1]
+	metrics.WebsocketClients.WithLabelValues(hub.moduleLabel(client.module)).Dec()
+	hub.logger.Debug("Websocket disconnected", "module", client.module, "organization", client.organization, "clients", len(hub.clients))
+	// Stops the writer, the hub no longer sends to the client
+	client.stop(websocket.CloseNormalClosure, "")
 }
 
-func (hub *Hub) Broadcast(message interface{}, neededRoles []string, modules []string) {
-	fmt.Println("send message")
-	fmt.Println(hub.clients)
+// Broadcast sends the message to the clients of the organization ctx is
+// scoped to, platform clients signed in without an organization get every message.
+func (hub *Hub) Broadcast(ctx context.Context, message interface{}, neededRoles []string, modules []string) {
+	organizations, err := scopeOrganizations(ctx)
+	if err != nil {
+		hub.logger.ErrorContext(ctx, "Dropped a broadcast without a tenant scope", "error", err)
+		return
+	}
+	hub.deliver(ctx, organizations, message, neededRoles, modules)
+}
+
+// BroadcastToGroups works like Broadcast but targets the members of any of
+// the groups. Group membership is read when the client connects and kept up
+// to date by SetGroupMember.
+func (hub *Hub) BroadcastToGroups(ctx context.Context, message interface{}, groups []string, modules []string) {
+	organizations, err := scopeOrganizations(ctx)
+	if err != nil {
+		hub.logger.ErrorContext(ctx, "Dropped a broadcast without a tenant scope", "error", err)
+		return
+	}
+	hub.send(ctx, organizations, message, func(client *Client) bool {
+		return ValidateGroups(groups, client.groups) && ValidateModules(client.module, modules)
+	})
+}
+
+// scopeOrganizations lists the organization ctx is scoped to, none when unscoped
+func scopeOrganizations(ctx context.Context) ([]string, error) {
+	organizationId, scoped, err := tenant.Organization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !scoped {
+		return []string{}, nil
+	}
+	return []string{organizationId}, nil
+}
+
+// SetGroupMember adds the group to the connected clients of the user, or
+// removes it, so group messages follow membership changes without reconnecting
+func (hub *Hub) SetGroupMember(userId string, groupId string, member bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for _, client := range hub.clients {
+		if client.userId != userId {
+			continue
+		}
+		groups := []string{}
+		for _, group := range client.groups {
+			if group != groupId {
+				groups = append(groups, group)
+			}
+		}
+		if member {
+			groups = append(groups, groupId)
+		}
+		client.groups = groups
+	}
+}
+
+func (hub *Hub) deliver(ctx context.Context, organizations []string, message interface{}, neededRoles []string, modules []string) {
+	hub.send(ctx, organizations, message, func(client *Client) bool {
+		return ValidateRoles(neededRoles, client.roles) && ValidateModules(client.module, modules)
+	})
+}
+
+// send queues the message for every target client without waiting on slow
+// ones, a client whose buffer is full misses the message.
+func (hub *Hub) send(ctx context.Context, organizations []string, message interface{}, target func(client *Client) bool) {
+	ctx, span := tracing.Start(ctx, "websocket.Broadcast")
+	defer span.End()
+	if websocketMessage, ok := message.(models.WebsocketMessage); ok {
+		websocketMessage.Metadata = tracing.Inject(ctx)
+		span.SetAttributes(attribute.String("websocket.code", websocketMessage.Code))
+		message = websocketMessage
+	}
 
 	data, _ := json.Marshal(message)
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	recipients := 0
 	for _, client := range hub.clients {
-		if ValidateRoles(neededRoles, client.roles) && ValidateModules(client.module, modules) {
-			client.outbound <- data
+		if !ValidateOrganization(client, organizations) || !target(client) {
+			continue
+		}
+		select {
+		case client.outbound <- data:
+			recipients++
+		default:
+			metrics.BroadcastDropped.Inc()
+			hub.logger.Warn("Websocket client is too slow, message dropped", "module", client.module, "organization", client.organization)
 		}
 	}
+	metrics.BroadcastRecipients.Observe(float64(recipients))
+	span.SetAttributes(attribute.Int("websocket.recipients", recipients))
 }
 
-func ValidateTokenAndGetProfile(JWTSecret string, tokenString string, ctx context.Context) (*models.Profile, error) {
+// ClientInfo describes a connected client, the token it signed in with is left out
+type ClientInfo struct {
+	UserId       string
+	Name         string
+	Module       string
+	Organization string
+	Roles        []string
+	RemoteAddr   string
+	ConnectedAt  time.Time
+	// Messages waiting to be written
+	Queued int
+}
+
+// Clients lists the connected clients visible from the organization ctx is scoped to
+func (hub *Hub) Clients(ctx context.Context) []ClientInfo {
+	organizations, err := scopeOrganizations(ctx)
+	if err != nil {
+		return []ClientInfo{}
+	}
+	scoped := len(organizations) > 0
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	clients := []ClientInfo{}
+	for _, client := range hub.clients {
+		// Unlike messages, scoped viewers don't see platform clients
+		if scoped && (!client.scoped || !ValidateOrganization(client, organizations)) {
+			continue
+		}
+		clients = append(clients, ClientInfo{
+			UserId:       client.userId,
+			Name:         client.name,
+			Module:       client.module,
+			Organization: client.organization,
+			Roles:        client.roles,
+			RemoteAddr:   client.socket.RemoteAddr().String(),
+			ConnectedAt:  client.connectedAt,
+			Queued:       len(client.outbound),
+		})
+	}
+	return clients
+}
+
+// ValidateTokenAndGetProfile also returns ctx scoped to the organization the user signed in to
+func ValidateTokenAndGetProfile(JWTSecret string, tokenString string, ctx context.Context) (*models.Profile, context.Context, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &models.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(JWTSecret), nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if claims, ok := token.Claims.(*models.AppClaims); ok && token.Valid {
 		userId := claims.UserId.Hex()
-		profile, err := repository.GetUserById(ctx, userId)
+		// Looking the user up inside the organization checks they still belong to it
+		lookupCtx := tenant.Unscoped(ctx)
+		if !claims.OrganizationId.IsZero() {
+			lookupCtx = tenant.WithOrganization(ctx, claims.OrganizationId.Hex())
+		}
+		profile, err := repository.GetUserById(lookupCtx, userId)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		return profile, nil
+		logging.SetUserId(ctx, userId)
+		return profile, tenant.ForUser(ctx, claims.OrganizationId, profile.Roles), nil
 	} else {
-		return nil, err
+		return nil, nil, err
+	}
+}
+
+// ValidateOrganization keeps messages inside the organizations they belong to
+func ValidateOrganization(client *Client, organizations []string) bool {
+	if !client.scoped {
+		return true
+	}
+	for _, organizationId := range organizations {
+		if organizationId == client.organization {
+			return true
+		}
 	}
+	return false
 }
 
 func ValidateRoles(neededRoles []string, roles []string) bool {
+	if tenant.IsPlatformAdmin(roles) {
+		return true
+	}
 	for _, r := range neededRoles {
 		for _, role := range roles {
 			if r == role {
@@ -154,6 +436,17 @@ func ValidateRoles(neededRoles []string, roles []string) bool {
 	return false
 }
 
+func ValidateGroups(neededGroups []string, groups []string) bool {
+	for _, g := range neededGroups {
+		for _, group := range groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func ValidateModules(module string, modules []string) bool {
 	for _, currModule := range modules {
 		if currModule == module {
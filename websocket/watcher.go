@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danielgz405/template-api-rest-go/models"
+	"github.com/danielgz405/template-api-rest-go/repository"
+)
+
+// WatchTarget tells the watcher which collection to follow and who receives its changes
+type WatchTarget struct {
+	Collection string
+	Code       string
+	Roles      []string
+	Modules    []string
+}
+
+type Watcher struct {
+	hub     *Hub
+	targets []WatchTarget
+	// wait before reopening a change stream that failed
+	retryDelay time.Duration
+}
+
+func NewWatcher(hub *Hub, retryDelay time.Duration, targets ...WatchTarget) *Watcher {
+	return &Watcher{
+		hub:        hub,
+		targets:    targets,
+		retryDelay: retryDelay,
+	}
+}
+
+// Run follows every target until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range w.targets {
+		wg.Add(1)
+		go func(target WatchTarget) {
+			defer wg.Done()
+			defer w.hub.recoverPanic("watch " + target.Collection)
+			w.watch(ctx, target)
+		}(target)
+	}
+	wg.Wait()
+}
+
+func (w *Watcher) watch(ctx context.Context, target WatchTarget) {
+	stream := "websocket:" + target.Collection
+	for {
+		// Reload the token on every attempt so a reconnect resumes after the last delivered event
+		token, err := repository.GetResumeToken(ctx, stream)
+		if err == nil {
+			err = repository.WatchCollection(ctx, target.Collection, token, func(event models.ChangeEvent) error {
+				if !w.hub.consumeOriginated(event.Collection, event.DocumentId) {
+					// Deletes carry no organizations so only platform clients get them
+					w.hub.deliver(ctx, event.Organizations, models.WebsocketMessage{
+						// codes are used to identify to where (modules) and what to does the message (create, update, delete, etc.)
+						Code:    target.Code,
+						Payload: event.Document,
+						User:    "system",
+					}, target.Roles, target.Modules)
+				}
+				return repository.SaveResumeToken(ctx, stream, event.ResumeToken)
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		w.hub.logger.Error("Watching changes failed, retrying", "collection", target.Collection, "error", err, "retry_in", w.retryDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.retryDelay):
+		}
+	}
+}
+
+// MarkOriginated records that a handler broadcasts a change to the document
+// itself, so the watcher skips the change event the write produces. It must
+// be called before the write commits, the event may arrive right after.
+func (hub *Hub) MarkOriginated(collection string, documentId string) {
+	hub.originatedMutex.Lock()
+	defer hub.originatedMutex.Unlock()
+
+	now := time.Now()
+	for key, at := range hub.originated {
+		if now.Sub(at) > hub.originatedWindow {
+			delete(hub.originated, key)
+		}
+	}
+	hub.originated[collection+":"+documentId] = now
+}
+
+// ForgetOriginated drops the mark of a write that didn't commit
+func (hub *Hub) ForgetOriginated(collection string, documentId string) {
+	hub.originatedMutex.Lock()
+	defer hub.originatedMutex.Unlock()
+
+	delete(hub.originated, collection+":"+documentId)
+}
+
+func (hub *Hub) consumeOriginated(collection string, documentId string) bool {
+	hub.originatedMutex.Lock()
+	defer hub.originatedMutex.Unlock()
+
+	key := collection + ":" + documentId
+	at, ok := hub.originated[key]
+	if !ok {
+		return false
+	}
+	delete(hub.originated, key)
+	return time.Since(at) <= hub.originatedWindow
+}